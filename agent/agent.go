@@ -0,0 +1,235 @@
+// Package agent keeps the key of an encrypted history in the memory of the
+// running daemon and hands it to other clipcli processes of the same user.
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// SocketName is the file name of the agent socket.
+const SocketName = "agent.sock"
+
+// dialTimeout bounds how long clients wait to reach the agent.
+const dialTimeout = 2 * time.Second
+
+// requestTimeout bounds a whole request; unlocking runs the passphrase KDF.
+const requestTimeout = 10 * time.Second
+
+// ErrNotRunning is returned by clients when no agent is listening.
+var ErrNotRunning = errors.New("clipcli daemon is not running")
+
+// Keyring holds the history key on behalf of the agent.
+type Keyring interface {
+	Unlock(secret []byte) error
+	Key() []byte
+	Lock()
+}
+
+// request is a single client command sent as one JSON line.
+type request struct {
+	Cmd    string `json:"cmd"` // "unlock", "key" or "lock"
+	Secret []byte `json:"secret,omitempty"`
+}
+
+// response answers a request.
+type response struct {
+	Error string `json:"error,omitempty"`
+	Key   []byte `json:"key,omitempty"`
+}
+
+// SocketPath returns the path of the agent socket of storage.Profile,
+// preferring the per-user runtime directory.
+func SocketPath() (string, error) {
+	if rt := os.Getenv("XDG_RUNTIME_DIR"); rt != "" {
+		dir := filepath.Join(rt, "clipcli")
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return "", err
+		}
+		name := SocketName
+		if storage.Profile != storage.DefaultProfile {
+			name = storage.Profile + "-" + SocketName
+		}
+		return filepath.Join(dir, name), nil
+	}
+	dir, err := storage.DataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, SocketName), nil
+}
+
+// Server answers agent requests on a unix socket.
+type Server struct {
+	ln     net.Listener
+	path   string
+	keys   Keyring
+	logger *log.Logger
+}
+
+// Listen creates the agent socket. A stale socket left behind by a crashed
+// daemon is replaced; a live one is reported as an error.
+func Listen(keys Keyring, logger *log.Logger) (*Server, error) {
+	p, err := SocketPath()
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := net.DialTimeout("unix", p, dialTimeout); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("agent already running on %s", p)
+	}
+	os.Remove(p)
+	ln, err := net.Listen("unix", p)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(p, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return &Server{ln: ln, path: p, keys: keys, logger: logger}, nil
+}
+
+// Serve accepts connections until the server is closed.
+func (s *Server) Serve() error {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.handle(conn)
+	}
+}
+
+// Close stops the server and removes its socket.
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	os.Remove(s.path)
+	return err
+}
+
+// handle answers one request from conn.
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+
+	if err := checkPeer(conn); err != nil {
+		s.logger.Printf("agent: rejected connection: %v\n", err)
+		return
+	}
+	var req request
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		return
+	}
+	var resp response
+	switch req.Cmd {
+	case "unlock":
+		if err := s.keys.Unlock(req.Secret); err != nil {
+			resp.Error = err.Error()
+		} else {
+			s.logger.Println("agent: history unlocked")
+		}
+	case "key":
+		if resp.Key = s.keys.Key(); resp.Key == nil {
+			resp.Error = storage.ErrLocked.Error()
+		}
+	case "lock":
+		s.keys.Lock()
+		s.logger.Println("agent: history locked")
+	default:
+		resp.Error = fmt.Sprintf("unknown command %q", req.Cmd)
+	}
+	json.NewEncoder(conn).Encode(resp)
+}
+
+// checkPeer only admits processes running as the agent's own user.
+func checkPeer(conn net.Conn) error {
+	uc, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("not a unix connection")
+	}
+	raw, err := uc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	}); err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+	if int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("peer uid %d", cred.Uid)
+	}
+	return nil
+}
+
+// call sends req to the agent and returns its answer.
+func call(req request) (response, error) {
+	var resp response
+	p, err := SocketPath()
+	if err != nil {
+		return resp, err
+	}
+	conn, err := net.DialTimeout("unix", p, dialTimeout)
+	if err != nil {
+		return resp, ErrNotRunning
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return resp, err
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return resp, err
+	}
+	if resp.Error != "" {
+		return resp, remoteError(resp.Error)
+	}
+	return resp, nil
+}
+
+// remoteError maps an error message from the agent back to the storage
+// sentinel it came from, if any.
+func remoteError(msg string) error {
+	for _, known := range []error{storage.ErrLocked, storage.ErrWrongKey, storage.ErrNotEncrypted} {
+		if msg == known.Error() {
+			return known
+		}
+	}
+	return errors.New(msg)
+}
+
+// Unlock asks the agent to derive and keep the history key.
+func Unlock(secret []byte) error {
+	_, err := call(request{Cmd: "unlock", Secret: secret})
+	return err
+}
+
+// Key fetches the history key held by the agent.
+func Key() ([]byte, error) {
+	resp, err := call(request{Cmd: "key"})
+	return resp.Key, err
+}
+
+// Lock asks the agent to forget the history key.
+func Lock() error {
+	_, err := call(request{Cmd: "lock"})
+	return err
+}
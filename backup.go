@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// cmdBackup lists, takes or restores history backups.
+func cmdBackup(store storage.Store, args []string) error {
+	fs, err := fileStore(store)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "list":
+		backups, err := storage.ListBackups(fs.Dir())
+		if err != nil {
+			return err
+		}
+		if len(backups) == 0 {
+			fmt.Println("(no backups)")
+			return nil
+		}
+		now := time.Now()
+		for i, b := range backups {
+			fmt.Printf("[%d] %s %7s taken=%s\n", i, b.Name, storage.FormatSize(int(b.Size)), storage.FormatAge(b.Time, now))
+		}
+		return nil
+
+	case "now":
+		info, created, err := fs.Backup(time.Now())
+		if err != nil {
+			return err
+		}
+		if !created {
+			fmt.Printf("history unchanged since backup %s\n", info.Name)
+			return nil
+		}
+		fmt.Printf("backup %s written\n", info.Name)
+		return nil
+
+	case "restore":
+		var ref string
+		merge := false
+		for _, a := range args[1:] {
+			if a == "--merge" {
+				merge = true
+			} else {
+				ref = a
+			}
+		}
+		if ref == "" {
+			return fmt.Errorf("backup restore requires a backup (see clipcli backup list)")
+		}
+		b, err := fs.ResolveBackup(ref)
+		if err != nil {
+			return err
+		}
+		added, err := fs.RestoreBackup(b, merge)
+		if err != nil {
+			return err
+		}
+		if merge {
+			fmt.Printf("merged backup %s: %d entries added\n", b.Name, added)
+		} else {
+			fmt.Printf("restored backup %s: %d entries (previous history moved to the trash)\n", b.Name, added)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown backup command %q (use list, now or restore)", args[0])
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	clipboardPkg "github/phaneendra24/goclipboard-manager/clipboard"
+	"github/phaneendra24/goclipboard-manager/config"
+	"github/phaneendra24/goclipboard-manager/daemon"
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// clipboardStateEnv is set by wl-paste --watch to "data" for a new
+// selection, "nil" when the clipboard was cleared and "sensitive" when the
+// source marked it as a password.
+const clipboardStateEnv = "CLIPBOARD_STATE"
+
+// isTextMIME reports whether a MIME type or X11 target names plain text.
+func isTextMIME(mime string) bool {
+	return strings.HasPrefix(mime, "text/") || mime == "UTF8_STRING" || mime == "STRING" || mime == "TEXT"
+}
+
+// cmdSave records the current clipboard with the daemon's capture rules,
+// so secrets and ignored applications are handled as secrets says. Content
+// that is not recorded is reported as an error.
+func cmdSave(store storage.Store, cfg *config.Config) error {
+	c, err := clipboardPkg.ReadContent(cfg.Capture.Images)
+	if err != nil {
+		return fmt.Errorf("read clipboard: %w", err)
+	}
+	e := storage.Entry{Content: c.Data, MIME: c.MIME, Source: storage.SourceSave}
+	if c.Password {
+		e.Secret = storage.SecretPassword
+	}
+	_, _, err = daemon.Capture(store, cfg, &e)
+	return err
+}
+
+// cmdStore records one clipboard payload read from stdin with the daemon's
+// capture rules, so `wl-paste --watch clipcli store` captures every change
+// without polling. PRIMARY payloads are only recorded when capture.primary
+// is set; either is copied to the other selection as capture.sync says.
+// Passwords and other secrets are handled as secrets.policy says and not
+// copied.
+func cmdStore(store storage.Store, cfg *config.Config, args []string) error {
+	fset := flag.NewFlagSet("store", flag.ContinueOnError)
+	mime := fset.String("mime", "", "MIME type of the payload (default: detect text or image)")
+	selection := fset.String("selection", storage.SelectionClipboard, "selection the payload comes from: clipboard or primary")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fset.Arg(0))
+	}
+	other := storage.SelectionPrimary
+	switch *selection {
+	case storage.SelectionClipboard:
+	case storage.SelectionPrimary:
+		other = storage.SelectionClipboard
+	default:
+		return fmt.Errorf("unknown selection %q (want clipboard or primary)", *selection)
+	}
+	secret := ""
+	switch state := os.Getenv(clipboardStateEnv); state {
+	case "", "data":
+	case "nil":
+		return nil // cleared
+	case "sensitive":
+		secret = storage.SecretPassword
+	default:
+		return fmt.Errorf("unknown %s %q", clipboardStateEnv, state)
+	}
+	data, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	e := storage.Entry{Content: string(data), Source: storage.SourceStore, Selection: *selection, Secret: secret}
+	switch {
+	case *mime == "":
+		// wl-paste --watch does not say which type it picked
+		if !utf8.Valid(data) {
+			if e.MIME = storage.ImageMIME(data); e.MIME == "" {
+				return errors.New("not storing binary content: only text and images are supported")
+			}
+		}
+	case isTextMIME(*mime):
+		if !utf8.Valid(data) {
+			return fmt.Errorf("not storing %s content: it is not UTF-8 text", *mime)
+		}
+	case strings.HasPrefix(*mime, "image/"):
+		e.MIME = *mime
+	default:
+		return fmt.Errorf("not storing %s content: only text and images are supported", *mime)
+	}
+	if e.IsBinary() && !cfg.Capture.Images {
+		return nil
+	}
+	if !e.IsBinary() && strings.TrimSpace(e.Content) == "" {
+		return nil
+	}
+	if *selection == storage.SelectionClipboard || cfg.Capture.Primary {
+		captured, _, err := daemon.Capture(store, cfg, &e)
+		if errors.Is(err, daemon.ErrSecret) || errors.Is(err, daemon.ErrIgnoredApp) {
+			return nil
+		}
+		if err != nil || !captured {
+			return err
+		}
+	}
+	// Only text is synced between the selections, and no secrets
+	if e.IsBinary() || e.Sensitive() {
+		return nil
+	}
+	txt := e.Content
+	if !cfg.Capture.SyncsTo(other) {
+		return nil
+	}
+	// Copying changes the other selection, whose watcher runs store again;
+	// leave it alone when it already holds txt so they do not loop
+	if cur, err := clipboardPkg.ReadSelection(other); err == nil && cur == txt {
+		return nil
+	}
+	return clipboardPkg.WriteSelection(other, txt)
+}
@@ -0,0 +1,113 @@
+package clipboard
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ActiveApp returns the names of the application whose window has the
+// focus, which is normally the one that just copied: its window class or
+// app ID and its process name. It asks xdotool on X11 and hyprctl or
+// swaymsg under Wayland, and returns nothing when none of them can tell.
+func ActiveApp() []string {
+	if isWayland() {
+		if names := hyprlandApp(); names != nil {
+			return names
+		}
+		return swayApp()
+	}
+	var names []string
+	if out, err := exec.Command("xdotool", "getactivewindow", "getwindowclassname").Output(); err == nil {
+		names = appendName(names, string(out))
+	}
+	if out, err := exec.Command("xdotool", "getactivewindow", "getwindowpid").Output(); err == nil {
+		if pid, err := strconv.Atoi(strings.TrimSpace(string(out))); err == nil {
+			names = appendName(names, processName(pid))
+		}
+	}
+	return names
+}
+
+// hyprlandApp asks Hyprland for the focused window.
+func hyprlandApp() []string {
+	out, err := exec.Command("hyprctl", "activewindow", "-j").Output()
+	if err != nil {
+		return nil
+	}
+	var win struct {
+		Class        string `json:"class"`
+		InitialClass string `json:"initialClass"`
+		PID          int    `json:"pid"`
+	}
+	if json.Unmarshal(out, &win) != nil {
+		return nil
+	}
+	return appendName(appendName(appendName(nil, win.Class), win.InitialClass), processName(win.PID))
+}
+
+// swayNode is a node of swaymsg's tree of outputs, workspaces and windows.
+type swayNode struct {
+	Focused          bool   `json:"focused"`
+	AppID            string `json:"app_id"`
+	PID              int    `json:"pid"`
+	WindowProperties struct {
+		Class string `json:"class"`
+	} `json:"window_properties"`
+	Nodes         []swayNode `json:"nodes"`
+	FloatingNodes []swayNode `json:"floating_nodes"`
+}
+
+// focused returns the focused node below n, or nil.
+func (n *swayNode) focused() *swayNode {
+	if n.Focused {
+		return n
+	}
+	for _, children := range [][]swayNode{n.Nodes, n.FloatingNodes} {
+		for i := range children {
+			if f := children[i].focused(); f != nil {
+				return f
+			}
+		}
+	}
+	return nil
+}
+
+// swayApp asks sway for the focused window.
+func swayApp() []string {
+	out, err := exec.Command("swaymsg", "-t", "get_tree", "-r").Output()
+	if err != nil {
+		return nil
+	}
+	var tree swayNode
+	if json.Unmarshal(out, &tree) != nil {
+		return nil
+	}
+	win := tree.focused()
+	if win == nil {
+		return nil
+	}
+	return appendName(appendName(appendName(nil, win.AppID), win.WindowProperties.Class), processName(win.PID))
+}
+
+// processName returns the command name of process pid, or "".
+func processName(pid int) string {
+	if pid <= 0 {
+		return ""
+	}
+	comm, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/comm")
+	if err != nil {
+		return ""
+	}
+	return string(comm)
+}
+
+// appendName appends name, trimmed, to names unless it is empty.
+func appendName(names []string, name string) []string {
+	if name = strings.TrimSpace(name); name != "" {
+		names = append(names, name)
+	}
+	return names
+}
@@ -2,15 +2,14 @@
 package clipboard
 
 import (
-	"errors"
 	"fmt"
 	"os"
 	"os/exec"
-	"strings"
 	"time"
 
 	"github.com/atotto/clipboard"
 
+	"github/phaneendra24/goclipboard-manager/config"
 	"github/phaneendra24/goclipboard-manager/storage"
 )
 
@@ -20,8 +19,9 @@ func isWayland() bool {
 }
 
 // SimulatePaste simulates Ctrl+V using the appropriate tool for the display server
-func SimulatePaste() error {
-	time.Sleep(30 * time.Millisecond)
+// after waiting cfg.Delay
+func SimulatePaste(cfg config.PasteConfig) error {
+	time.Sleep(time.Duration(cfg.Delay))
 	if isWayland() {
 		// Use wtype for Wayland
 		cmd := exec.Command("wtype", "-M", "ctrl", "v", "-m", "ctrl")
@@ -32,55 +32,38 @@ func SimulatePaste() error {
 	return cmd.Run()
 }
 
-// Save reads the current clipboard and saves it to history.
-func Save() error {
-	txt, err := clipboard.ReadAll()
-	if err != nil {
-		return fmt.Errorf("read clipboard: %w", err)
-	}
-	if strings.TrimSpace(txt) == "" {
-		return errors.New("clipboard empty or whitespace")
-	}
-	hist, err := storage.LoadHistory()
-	if err != nil {
-		return err
-	}
-	// avoid duplicate consecutive entries
-	if len(hist) == 0 || hist[0] != txt {
-		hist = append([]string{txt}, hist...)
-	}
-	return storage.SaveHistory(hist)
-}
-
 // Paste writes text to clipboard and simulates Ctrl+V.
-func Paste(text string) error {
+func Paste(text string, cfg config.PasteConfig) error {
 	if err := clipboard.WriteAll(text); err != nil {
 		return err
 	}
-	return SimulatePaste()
+	return SimulatePaste(cfg)
 }
 
-// PasteByIndex pastes the history item at the given index.
-func PasteByIndex(idx int) error {
-	hist, err := storage.LoadHistory()
+// PasteByRef pastes the history item a reference points at: either a
+// positional index (0 = most recent) or an entry ID prefix.
+func PasteByRef(store storage.Store, ref string, cfg config.PasteConfig) (storage.Entry, error) {
+	entry, err := store.Get(ref)
 	if err != nil {
-		return err
-	}
-	if len(hist) == 0 {
-		return errors.New("history empty")
+		return entry, err
 	}
-	if idx < 0 || idx >= len(hist) {
-		return fmt.Errorf("index out of range (0..%d)", len(hist)-1)
+	content, err := store.Content(entry)
+	if err != nil {
+		return entry, err
 	}
-	text := hist[idx]
 	// write to system clipboard
-	if err := clipboard.WriteAll(text); err != nil {
-		return fmt.Errorf("write clipboard: %w", err)
+	if err := CopyEntry(entry, content); err != nil {
+		return entry, fmt.Errorf("write clipboard: %w", err)
 	}
-	if err := SimulatePaste(); err != nil {
-		return fmt.Errorf("paste simulation failed: %w", err)
+	if err := SimulatePaste(cfg); err != nil {
+		return entry, fmt.Errorf("paste simulation failed: %w", err)
 	}
-	return nil
+	return entry, store.Update(func(clipData *storage.ClipboardData) error {
+		if i := clipData.IndexOf(entry.ID); i >= 0 {
+			clipData.MarkUsed(i)
+		}
+		return nil
+	})
 }
 
 // CopyToClipboard writes text to the system clipboard.
@@ -92,4 +75,3 @@ func CopyToClipboard(text string) error {
 func ReadClipboard() (string, error) {
 	return clipboard.ReadAll()
 }
-
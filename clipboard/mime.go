@@ -0,0 +1,165 @@
+package clipboard
+
+import (
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/atotto/clipboard"
+
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// errNoMIMETool is returned when no installed tool can read or write
+// clipboard content by MIME type.
+var errNoMIMETool = errors.New("no tool for typed clipboard content: install wl-clipboard or xclip")
+
+// textTargets are the targets of plain text, which is preferred over the
+// image renditions some applications offer along with it.
+var textTargets = []string{"text/plain", "text/plain;charset=utf-8", "UTF8_STRING", "STRING", "TEXT"}
+
+// mimeCommand returns the command that lists the clipboard's types (mime
+// "TARGETS"), reads the content of one type or, with write set, puts stdin
+// on the clipboard as that type.
+func mimeCommand(mime string, write bool) (*exec.Cmd, error) {
+	if isWayland() {
+		switch {
+		case write:
+			if _, err := exec.LookPath("wl-copy"); err == nil {
+				return exec.Command("wl-copy", "--type", mime), nil
+			}
+		case mime == "TARGETS":
+			if _, err := exec.LookPath("wl-paste"); err == nil {
+				return exec.Command("wl-paste", "--list-types"), nil
+			}
+		default:
+			if _, err := exec.LookPath("wl-paste"); err == nil {
+				return exec.Command("wl-paste", "--no-newline", "--type", mime), nil
+			}
+		}
+	}
+	if _, err := exec.LookPath("xclip"); err != nil {
+		return nil, errNoMIMETool
+	}
+	if write {
+		return exec.Command("xclip", "-in", "-selection", "clipboard", "-t", mime), nil
+	}
+	return exec.Command("xclip", "-out", "-selection", "clipboard", "-t", mime), nil
+}
+
+// imageTarget picks the image type to capture from the clipboard's types:
+// PNG if offered, else the first image type. Clipboards that also offer
+// plain text are read as text.
+func imageTarget(targets []string) string {
+	image := ""
+	for _, t := range targets {
+		for _, text := range textTargets {
+			if strings.EqualFold(t, text) {
+				return ""
+			}
+		}
+		if t == "image/png" || image == "" && strings.HasPrefix(t, "image/") {
+			image = t
+		}
+	}
+	return image
+}
+
+// passwordHintTarget is offered along with passwords by password managers,
+// such as KeePassXC, so clipboard managers do not keep them.
+const passwordHintTarget = "x-kde-passwordManagerHint"
+
+// Content is what ReadContent found on the clipboard.
+type Content struct {
+	Data string
+	MIME string // of binary Data; empty for text
+	// Password is set when the clipboard owner marked Data as a password
+	Password bool
+}
+
+// ReadContent reads the clipboard. It first lists the offered types to
+// spot passwords and, with images set, returns image content with its MIME
+// type when there is no text.
+func ReadContent(images bool) (Content, error) {
+	return readContent(images, clipboard.ReadAll)
+}
+
+// readContent is ReadContent reading text with readText.
+func readContent(images bool, readText func() (string, error)) (Content, error) {
+	var c Content
+	if cmd, err := mimeCommand("TARGETS", false); err == nil {
+		if out, err := cmd.Output(); err == nil {
+			targets := strings.Fields(string(out))
+			c.Password = slices.Contains(targets, passwordHintTarget)
+			if mime := imageTarget(targets); images && mime != "" {
+				data, err := readType(mime)
+				c.Data, c.MIME = data, mime
+				return c, err
+			}
+		}
+	}
+	var err error
+	c.Data, err = readText()
+	return c, err
+}
+
+// readType reads the clipboard content of one MIME type.
+func readType(mime string) (string, error) {
+	cmd, err := mimeCommand(mime, false)
+	if err != nil {
+		return "", err
+	}
+	data, err := cmd.Output()
+	return string(data), err
+}
+
+// Reader reads the clipboard over and over, as the daemon does, running as
+// few processes as it can: the text alone is read while it stays the same,
+// and so is an image of the type last seen while there is no text. The
+// offered types are only listed when the content changed.
+type Reader struct {
+	Images bool // read images copied without text
+	last   Content
+	valid  bool // last holds the content of the previous read
+}
+
+// Read returns the clipboard content. Set changed when the clipboard is
+// known to have a new owner, as XFixes reports, to read it afresh.
+func (r *Reader) Read(changed bool) (Content, error) {
+	readText := clipboard.ReadAll
+	if !changed && r.valid {
+		text, err := clipboard.ReadAll()
+		switch {
+		case err == nil && r.last.MIME == "" && text == r.last.Data:
+			return r.last, nil
+		case err != nil && r.last.MIME != "":
+			// Most likely the same image, which needs no type listing
+			if data, err := readType(r.last.MIME); err == nil && data == r.last.Data {
+				return r.last, nil
+			}
+		}
+		readText = func() (string, error) { return text, err }
+	}
+	c, err := readContent(r.Images, readText)
+	r.last, r.valid = c, err == nil
+	return c, err
+}
+
+// WriteContent puts content on the clipboard, as type mime when set.
+func WriteContent(content, mime string) error {
+	if mime == "" {
+		return clipboard.WriteAll(content)
+	}
+	cmd, err := mimeCommand(mime, true)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = strings.NewReader(content)
+	return cmd.Run()
+}
+
+// CopyEntry puts the content of e on the clipboard with e's MIME type.
+func CopyEntry(e storage.Entry, content string) error {
+	return WriteContent(content, e.MIME)
+}
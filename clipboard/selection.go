@@ -0,0 +1,70 @@
+package clipboard
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+
+	"github.com/atotto/clipboard"
+
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// errNoSelectionTool is returned when no tool can access the PRIMARY
+// selection.
+var errNoSelectionTool = errors.New("no tool for the PRIMARY selection: install wl-clipboard, xclip or xsel")
+
+// primaryCommand returns the command that reads (or with write set, writes
+// through stdin) the PRIMARY selection with the first tool installed.
+func primaryCommand(write bool) (*exec.Cmd, error) {
+	var candidates [][]string
+	if isWayland() {
+		if write {
+			candidates = append(candidates, []string{"wl-copy", "--primary"})
+		} else {
+			candidates = append(candidates, []string{"wl-paste", "--primary", "--no-newline"})
+		}
+	}
+	if write {
+		candidates = append(candidates,
+			[]string{"xclip", "-in", "-selection", "primary"},
+			[]string{"xsel", "--primary", "--input"})
+	} else {
+		candidates = append(candidates,
+			[]string{"xclip", "-out", "-selection", "primary"},
+			[]string{"xsel", "--primary", "--output"})
+	}
+	for _, args := range candidates {
+		if _, err := exec.LookPath(args[0]); err == nil {
+			return exec.Command(args[0], args[1:]...), nil
+		}
+	}
+	return nil, errNoSelectionTool
+}
+
+// ReadSelection reads a selection: storage.SelectionClipboard or
+// storage.SelectionPrimary.
+func ReadSelection(selection string) (string, error) {
+	if selection != storage.SelectionPrimary {
+		return clipboard.ReadAll()
+	}
+	cmd, err := primaryCommand(false)
+	if err != nil {
+		return "", err
+	}
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// WriteSelection replaces the content of a selection.
+func WriteSelection(selection, text string) error {
+	if selection != storage.SelectionPrimary {
+		return clipboard.WriteAll(text)
+	}
+	cmd, err := primaryCommand(true)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = strings.NewReader(text)
+	return cmd.Run()
+}
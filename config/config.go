@@ -2,8 +2,15 @@
 package config
 
 import (
+	"errors"
+	"fmt"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
@@ -11,20 +18,311 @@ import (
 const (
 	configDirName  = "clipcli"
 	configFileName = "config.toml"
+	// profilesDirName holds per-profile overlays, e.g. profiles/work.toml
+	profilesDirName = "profiles"
 )
 
-// Config holds all application settings
+// Config holds all application settings. It is the single source of truth
+// for every package; the defaults are listed in DefaultConfig.
 type Config struct {
+	// MaxHistory is how many entries are kept (10-10000, default 500)
 	MaxHistory int `toml:"max_history"`
-	PollMS     int `toml:"poll_ms"`
+	// MaxEntryBytes limits a single entry (0 = unlimited, default 16 MiB);
+	// OversizePolicy is "skip" (default) or "truncate" for larger ones
+	MaxEntryBytes  int    `toml:"max_entry_bytes"`
+	OversizePolicy string `toml:"oversize_policy"`
+	// MaxTotalBytes limits the whole history (0 = unlimited, the default);
+	// the oldest unpinned entries are evicted first
+	MaxTotalBytes int64 `toml:"max_total_bytes"`
+	// TrashRetention is how long deleted entries stay restorable ("0"
+	// disables the trash, default "7d")
+	TrashRetention Duration `toml:"trash_retention"`
+	// PollMS is the daemon's clipboard polling interval (50-5000, default 300)
+	PollMS     int              `toml:"poll_ms"`
+	Capture    CaptureConfig    `toml:"capture"`
+	Secrets    SecretsConfig    `toml:"secrets"`
+	Storage    StorageConfig    `toml:"storage"`
+	Encryption EncryptionConfig `toml:"encryption"`
+	Retention  RetentionConfig  `toml:"retention"`
+	Backup     BackupConfig     `toml:"backup"`
+	Paste      PasteConfig      `toml:"paste"`
+	Preview    PreviewConfig    `toml:"preview"`
+	UI         UIConfig         `toml:"ui"`
+}
+
+// Selection synchronization directions.
+const (
+	SyncNone        = "none"         // leave the selections alone (default)
+	SyncToPrimary   = "to-primary"   // copy new CLIPBOARD content to PRIMARY
+	SyncToClipboard = "to-clipboard" // copy finished PRIMARY selections to CLIPBOARD
+	SyncBoth        = "both"
+)
+
+// CaptureConfig controls how the daemon notices clipboard changes
+type CaptureConfig struct {
+	// WLPaste makes the daemon run `wl-paste --watch clipcli store` under
+	// Wayland instead of polling (default false)
+	WLPaste bool `toml:"wl_paste"`
+	// Primary also captures the PRIMARY (middle-click) selection, polled
+	// every poll_ms (default false)
+	Primary bool `toml:"primary"`
+	// Sync is "none" (default), "to-primary", "to-clipboard" or "both"
+	Sync string `toml:"sync"`
+	// Images also captures images copied without text, such as
+	// screenshots (default true)
+	Images bool `toml:"images"`
+}
+
+// SyncsTo reports whether content of the other selection is copied to
+// selection ("clipboard" or "primary").
+func (c CaptureConfig) SyncsTo(selection string) bool {
+	switch selection {
+	case "primary":
+		return c.Sync == SyncToPrimary || c.Sync == SyncBoth
+	case "clipboard":
+		return c.Sync == SyncToClipboard || c.Sync == SyncBoth
+	}
+	return false
+}
+
+// WatchesPrimary reports whether PRIMARY has to be read at all.
+func (c CaptureConfig) WatchesPrimary() bool {
+	return c.Primary || c.SyncsTo("clipboard")
+}
+
+// Secret policies.
+const (
+	SecretsFlag = "flag" // keep secrets marked sensitive, masked and expiring after ttl (default)
+	SecretsSkip = "skip" // do not store them
+)
+
+// SecretsConfig controls how passwords and other secrets are captured.
+// Content is a secret when the application that copied it offers the
+// x-kde-passwordManagerHint target, as password managers do, or when it
+// holds a private key, an AWS, GCP or GitHub token, a JWT or a card number.
+type SecretsConfig struct {
+	// Policy is "flag" (default) or "skip"
+	Policy string `toml:"policy"`
+	// TTL is how long flagged secrets are kept, pinned or not ("0" keeps
+	// them like other entries, default "2m")
+	TTL Duration `toml:"ttl"`
+	// IgnoreApps lists applications whose copies are never stored, by
+	// window class or process name, e.g. ["keepassxc"]. The focused
+	// application is known on X11 (with xdotool), Hyprland and sway.
+	IgnoreApps []string `toml:"ignore_apps"`
+}
+
+// StorageConfig selects where history is kept
+type StorageConfig struct {
+	// Backend is "json" (history file in the data directory, the default) or
+	// "memory" (ephemeral, nothing written to disk)
+	Backend string `toml:"backend"`
+	// BlobThreshold is the entry size in bytes above which content is stored
+	// as a separate compressed blob (0 keeps everything inline, default 64 KiB)
+	BlobThreshold int `toml:"blob_threshold"`
+}
+
+// EncryptionConfig describes how an encrypted history is unlocked
+type EncryptionConfig struct {
+	// Keyfile holds a 32-byte key (raw, hex or base64). When empty the key is
+	// derived from a passphrase taken from $CLIPCLI_PASSPHRASE or from
+	// `clipcli unlock`.
+	Keyfile string `toml:"keyfile"`
+}
+
+// RetentionConfig limits how long unpinned entries are kept. Empty or zero
+// ages keep entries forever, which is the default.
+type RetentionConfig struct {
+	MaxAge Duration `toml:"max_age"`
+	// Types overrides MaxAge per content type ("text", "url", "path" or
+	// "image"), e.g. url = "30d"
+	Types map[string]Duration `toml:"types"`
+}
+
+// Duration is a configured length of time: a Go duration such as "90m" or
+// whole days and weeks such as "7d" or "2w". An empty string is zero.
+type Duration time.Duration
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalText implements encoding.TextMarshaler, preferring whole days.
+func (d Duration) MarshalText() ([]byte, error) {
+	const day = 24 * time.Hour
+	if v := time.Duration(d); v > 0 && v%day == 0 {
+		return []byte(strconv.FormatInt(int64(v/day), 10) + "d"), nil
+	}
+	return []byte(time.Duration(d).String()), nil
+}
+
+// ParseDuration parses a Go duration, also accepting whole days ("7d") and
+// weeks ("2w"). An empty string is zero.
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(s, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(s, "w"):
+		unit = 7 * 24 * time.Hour
+	default:
+		d, err := time.ParseDuration(s)
+		if err == nil && d < 0 {
+			err = fmt.Errorf("negative duration %q", s)
+		}
+		return d, err
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// BackupConfig controls the daemon's automatic backups
+type BackupConfig struct {
+	// Interval between backups ("0" disables them, default "1h")
+	Interval Duration `toml:"interval"`
+	// Daily and Weekly are how many recent days and weeks keep a backup
+	// (defaults 7 and 4)
+	Daily  int `toml:"daily"`
+	Weekly int `toml:"weekly"`
+}
+
+// PasteConfig tunes the simulated Ctrl+V
+type PasteConfig struct {
+	// Delay is the pause before the keystroke is sent (up to 5s, default 30ms)
+	Delay Duration `toml:"delay"`
+	// GUIDelay is how long the GUI waits for its window to close before
+	// pasting into the window below (up to 5s, default 100ms)
+	GUIDelay Duration `toml:"gui_delay"`
+}
+
+// PreviewConfig sets how many bytes of an entry's first line are shown
+// (each 10-10000)
+type PreviewConfig struct {
+	List int `toml:"list"` // clipcli list, search and trash list (default 200)
+	GUI  int `toml:"gui"`  // GUI rows (default 90)
+	Log  int `toml:"log"`  // daemon log and clipcli gc (default 80)
+}
+
+// Themes selectable for the GUI.
+const (
+	ThemeDracula = "dracula" // dark Dracula palette
+	ThemeSystem  = "system"  // Fyne's default, following the desktop's light/dark setting
+)
+
+// UIConfig controls the GUI window
+type UIConfig struct {
+	// Width and Height of the window (200-10000, defaults 700 and 500)
+	Width  int `toml:"width"`
+	Height int `toml:"height"`
+	// Theme is "dracula" (default) or "system"
+	Theme string `toml:"theme"`
 }
 
 // DefaultConfig returns the default configuration
 func DefaultConfig() *Config {
 	return &Config{
-		MaxHistory: 500,
-		PollMS:     300,
+		MaxHistory:     500,
+		MaxEntryBytes:  16 * 1024 * 1024,
+		OversizePolicy: "skip",
+		TrashRetention: Duration(7 * 24 * time.Hour),
+		Backup: BackupConfig{
+			Interval: Duration(time.Hour),
+			Daily:    7,
+			Weekly:   4,
+		},
+		PollMS: 300,
+		Capture: CaptureConfig{
+			Sync:   SyncNone,
+			Images: true,
+		},
+		Secrets: SecretsConfig{
+			Policy: SecretsFlag,
+			TTL:    Duration(2 * time.Minute),
+		},
+		Storage: StorageConfig{
+			Backend:       "json",
+			BlobThreshold: 64 * 1024,
+		},
+		Paste: PasteConfig{
+			Delay:    Duration(30 * time.Millisecond),
+			GUIDelay: Duration(100 * time.Millisecond),
+		},
+		Preview: PreviewConfig{
+			List: 200,
+			GUI:  90,
+			Log:  80,
+		},
+		UI: UIConfig{
+			Width:  700,
+			Height: 500,
+			Theme:  ThemeDracula,
+		},
+	}
+}
+
+// Validate reports every setting that is out of range or unknown.
+func (cfg *Config) Validate() error {
+	var errs []error
+	checkRange := func(name string, v, lo, hi int) {
+		if v < lo || v > hi {
+			errs = append(errs, fmt.Errorf("%s = %d is out of range (%d-%d)", name, v, lo, hi))
+		}
+	}
+	checkOneOf := func(name, v string, allowed ...string) {
+		for _, a := range allowed {
+			if v == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s = %q must be one of %q", name, v, allowed))
+	}
+	checkRange("max_history", cfg.MaxHistory, 10, 10000)
+	checkRange("poll_ms", cfg.PollMS, 50, 5000)
+	if cfg.MaxEntryBytes < 0 {
+		errs = append(errs, fmt.Errorf("max_entry_bytes = %d must not be negative", cfg.MaxEntryBytes))
+	}
+	checkOneOf("oversize_policy", cfg.OversizePolicy, "skip", "truncate")
+	if cfg.MaxTotalBytes < 0 {
+		errs = append(errs, fmt.Errorf("max_total_bytes = %d must not be negative", cfg.MaxTotalBytes))
 	}
+	for _, typ := range slices.Sorted(maps.Keys(cfg.Retention.Types)) {
+		checkOneOf("retention.types key", typ, "text", "url", "path", "image")
+	}
+	checkOneOf("capture.sync", cfg.Capture.Sync, SyncNone, SyncToPrimary, SyncToClipboard, SyncBoth)
+	checkOneOf("secrets.policy", cfg.Secrets.Policy, SecretsFlag, SecretsSkip)
+	checkOneOf("storage.backend", cfg.Storage.Backend, "json", "memory")
+	if cfg.Storage.BlobThreshold < 0 {
+		errs = append(errs, fmt.Errorf("storage.blob_threshold = %d must not be negative", cfg.Storage.BlobThreshold))
+	}
+	checkRange("backup.daily", cfg.Backup.Daily, 0, 366)
+	checkRange("backup.weekly", cfg.Backup.Weekly, 0, 520)
+	checkDelay := func(name string, d Duration) {
+		if time.Duration(d) > 5*time.Second {
+			errs = append(errs, fmt.Errorf("%s = %v is out of range (0-5s)", name, time.Duration(d)))
+		}
+	}
+	checkDelay("paste.delay", cfg.Paste.Delay)
+	checkDelay("paste.gui_delay", cfg.Paste.GUIDelay)
+	checkRange("preview.list", cfg.Preview.List, 10, 10000)
+	checkRange("preview.gui", cfg.Preview.GUI, 10, 10000)
+	checkRange("preview.log", cfg.Preview.Log, 10, 10000)
+	checkRange("ui.width", cfg.UI.Width, 200, 10000)
+	checkRange("ui.height", cfg.UI.Height, 200, 10000)
+	checkOneOf("ui.theme", cfg.UI.Theme, ThemeDracula, ThemeSystem)
+	return errors.Join(errs...)
 }
 
 // configPath returns the path to the config file
@@ -40,42 +338,44 @@ func configPath() (string, error) {
 	return filepath.Join(xdgConfig, configDirName, configFileName), nil
 }
 
-// Load loads configuration from file, returning defaults if file doesn't exist
-func Load() (*Config, error) {
+// ProfilePath returns the path to the config overlay of a profile
+func ProfilePath(profile string) (string, error) {
+	path, err := configPath()
+	if err != nil || path == "" {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(path), profilesDirName, profile+".toml"), nil
+}
+
+// Load loads configuration from file, returning defaults if file doesn't exist.
+// Settings in the profile's overlay file take precedence over the main file.
+// Files that cannot be parsed and invalid settings are reported as errors.
+func Load(profile string) (*Config, error) {
 	cfg := DefaultConfig()
 
 	path, err := configPath()
 	if err != nil || path == "" {
 		return cfg, nil
 	}
-
-	data, err := os.ReadFile(path)
+	overlay, err := ProfilePath(profile)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return cfg, nil // Use defaults
-		}
 		return cfg, err
 	}
 
-	if err := toml.Unmarshal(data, cfg); err != nil {
-		return DefaultConfig(), err
-	}
-
-	// Validate and apply bounds
-	if cfg.MaxHistory < 10 {
-		cfg.MaxHistory = 10
-	}
-	if cfg.MaxHistory > 10000 {
-		cfg.MaxHistory = 10000
-	}
-	if cfg.PollMS < 50 {
-		cfg.PollMS = 50
-	}
-	if cfg.PollMS > 5000 {
-		cfg.PollMS = 5000
+	for _, p := range []string{path, overlay} {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // Use defaults
+			}
+			return cfg, err
+		}
+		if err := toml.Unmarshal(data, cfg); err != nil {
+			return cfg, fmt.Errorf("%s: %w", p, err)
+		}
 	}
 
-	return cfg, nil
+	return cfg, cfg.Validate()
 }
 
 // Save writes configuration to file
@@ -2,82 +2,369 @@
 package daemon
 
 import (
+	"errors"
+	"fmt"
 	"log"
+	"os"
 	"strings"
 	"time"
 
-	"github.com/atotto/clipboard"
-
+	clipboardPkg "github/phaneendra24/goclipboard-manager/clipboard"
+	"github/phaneendra24/goclipboard-manager/config"
 	"github/phaneendra24/goclipboard-manager/storage"
+	"github/phaneendra24/goclipboard-manager/x11"
 )
 
-// Run starts the daemon that polls the clipboard at the given interval.
-// It saves new clipboard contents to history and logs activity.
+// expireInterval is how often the daemon applies the retention policy.
+const expireInterval = time.Minute
+
+// expiryInterval returns how often the daemon applies p: every
+// expireInterval, or more often so that secrets do not outlive their TTL by
+// much.
+func expiryInterval(p storage.RetentionPolicy) time.Duration {
+	if p.Sensitive > 0 && p.Sensitive < 4*expireInterval {
+		return max(p.Sensitive/4, time.Second)
+	}
+	return expireInterval
+}
+
+// Run starts the daemon that watches the clipboard for changes through
+// XFixes, or with cfg.Capture.WLPaste under Wayland runs wl-paste --watch
+// clipcli store, and otherwise polls it every cfg.PollMS milliseconds. The
+// PRIMARY selection, when captured or synced, is polled. The daemon saves
+// new clipboard contents to the store, expires entries that have outlived
+// storage.Retention and logs activity.
 // The daemon runs until stopCh is closed.
-func Run(pollMS int, logger *log.Logger, stopCh <-chan struct{}) error {
-	logger.Printf("daemon starting (poll %dms)\n", pollMS)
-	ticker := time.NewTicker(time.Duration(pollMS) * time.Millisecond)
+func Run(store storage.Store, cfg *config.Config, logger *log.Logger, stopCh <-chan struct{}) error {
+	logger.Println("daemon starting")
+	ticker := time.NewTicker(time.Duration(cfg.PollMS) * time.Millisecond)
 	defer ticker.Stop()
+	expiry := time.NewTicker(expiryInterval(storage.Retention))
+	defer expiry.Stop()
+	// A nil channel never fires, which disables backups
+	var backupC <-chan time.Time
+	fs, isFile := store.(*storage.FileStore)
+	if isFile && cfg.Backup.Interval > 0 {
+		backups := time.NewTicker(time.Duration(cfg.Backup.Interval))
+		defer backups.Stop()
+		backupC = backups.C
+		backup(fs, logger)
+	}
+
+	lastSeen := make(map[string]string) // selection to its last content
+	// syncedPrimary is the last PRIMARY content copied to or from CLIPBOARD
+	var syncedPrimary string
+	warnedLocked := false
+	// capture records e, logging the outcome. It reports whether e is now
+	// in the history.
+	capture := func(e *storage.Entry) bool {
+		txt, selection := e.Content, e.SelectionName()
+		e.Source = storage.SourceDaemon
+		captured, histLen, err := Capture(store, cfg, e)
+		if errors.Is(err, ErrBlank) {
+			return false
+		}
+		if errors.Is(err, ErrOversize) {
+			logger.Printf("skipped %s capture (max_entry_bytes %s)\n",
+				storage.FormatSize(len(txt)), storage.FormatSize(cfg.MaxEntryBytes))
+			lastSeen[selection] = txt
+			return false
+		}
+		if errors.Is(err, ErrSecret) || errors.Is(err, ErrIgnoredApp) {
+			logger.Printf("skipped %s capture: %v\n", selection, err)
+			lastSeen[selection] = txt
+			return false
+		}
+		if errors.Is(err, storage.ErrLocked) {
+			// Keep watching: captures resume once clipcli unlock hands over the key
+			if !warnedLocked {
+				logger.Println("history is locked, not capturing until unlocked")
+				warnedLocked = true
+			}
+			return false
+		}
+		warnedLocked = false
+		if errors.Is(err, storage.ErrCorrupt) {
+			quarantine(store, logger)
+			return false // capture again on the next change or tick
+		}
+		if err != nil {
+			logger.Printf("save history error: %v\n", err)
+			return false
+		}
+		lastSeen[selection] = txt
+		switch {
+		case captured && isFile && encryptedHistory(fs):
+			// The log is not encrypted, so it only names the entry
+			logger.Printf("captured %s (len=%d) entry %s of %s\n", selection, histLen, storage.HashID(txt), storage.FormatSize(len(txt)))
+		case captured:
+			logger.Printf("captured %s (len=%d) preview: %q\n", selection, histLen, storage.Preview(e.DisplayText(), cfg.Preview.Log))
+		}
+		return true
+	}
+	reader := &clipboardPkg.Reader{Images: cfg.Capture.Images}
+	// check reads the clipboard, afresh when it is known to have changed,
+	// and captures it if it did. Secrets are not synced to PRIMARY, which
+	// any application can read.
+	check := func(changed bool) {
+		c, err := reader.Read(changed)
+		if err != nil {
+			logger.Printf("clipboard read error: %v\n", err)
+			return
+		}
+		txt := c.Data
+		if txt == lastSeen[storage.SelectionClipboard] {
+			return // no change
+		}
+		e := storage.Entry{Content: txt, MIME: c.MIME, Selection: storage.SelectionClipboard}
+		if c.Password {
+			e.Secret = storage.SecretPassword
+		}
+		if !capture(&e) || e.IsBinary() || e.Sensitive() || !cfg.Capture.SyncsTo(storage.SelectionPrimary) ||
+			txt == lastSeen[storage.SelectionPrimary] {
+			return
+		}
+		if err := clipboardPkg.WriteSelection(storage.SelectionPrimary, txt); err != nil {
+			logger.Printf("sync to primary error: %v\n", err)
+			return
+		}
+		lastSeen[storage.SelectionPrimary], syncedPrimary = txt, txt
+	}
+	// checkPrimary reads the PRIMARY selection, which is often empty, so
+	// read errors are not logged. Selections grow while the mouse is
+	// dragged: captures collapse into one entry (see Capture), and syncing
+	// waits until the selection stayed the same for a tick.
+	checkPrimary := func() {
+		txt, err := clipboardPkg.ReadSelection(storage.SelectionPrimary)
+		if err != nil {
+			return
+		}
+		if txt != lastSeen[storage.SelectionPrimary] {
+			if cfg.Capture.Primary {
+				capture(&storage.Entry{Content: txt, Selection: storage.SelectionPrimary})
+			}
+			lastSeen[storage.SelectionPrimary] = txt
+			return
+		}
+		if !cfg.Capture.SyncsTo(storage.SelectionClipboard) || txt == syncedPrimary || strings.TrimSpace(txt) == "" {
+			return
+		}
+		if err := clipboardPkg.WriteSelection(storage.SelectionClipboard, txt); err != nil {
+			logger.Printf("sync to clipboard error: %v\n", err)
+			return
+		}
+		syncedPrimary = txt
+	}
 
-	var lastSeen string
+	// Prefer XFixes change events to polling: the clipboard is then only
+	// read when it changes, and copies replaced within a tick are not missed
+	pollClipboard, viaWLPaste := true, false
+	var changes <-chan string
+	if os.Getenv("WAYLAND_DISPLAY") != "" && cfg.Capture.WLPaste {
+		// wl-paste runs clipcli store on every change, so there is nothing
+		// to check here
+		if err := superviseWLPaste(cfg, logger, stopCh); err != nil {
+			logger.Printf("cannot run wl-paste --watch (%v), polling every %dms\n", err, cfg.PollMS)
+		} else {
+			logger.Println("capturing the clipboard with wl-paste --watch")
+			pollClipboard, viaWLPaste = false, true
+		}
+	} else if c, err := watchClipboard(stopCh); err != nil {
+		logger.Printf("clipboard change events unavailable (%v), polling every %dms\n", err, cfg.PollMS)
+	} else {
+		logger.Println("watching the clipboard for changes with XFixes")
+		changes, pollClipboard = c, false
+		check(true)
+	}
+	// wl-paste also watches PRIMARY; elsewhere it is polled
+	pollPrimary := cfg.Capture.WatchesPrimary() && !viaWLPaste
+	tick := ticker.C
+	if !pollClipboard && !pollPrimary {
+		tick = nil
+	}
 	for {
 		select {
 		case <-stopCh:
 			logger.Println("daemon stopping (received stop)")
 			return nil
-		case <-ticker.C:
-			txt, err := clipboard.ReadAll()
-			if err != nil {
-				logger.Printf("clipboard read error: %v\n", err)
+		case <-backupC:
+			backup(fs, logger)
+		case <-expiry.C:
+			if !storage.Retention.Enabled() {
 				continue
 			}
-			// Ignore empty strings
-			if strings.TrimSpace(txt) == "" {
+			expired, err := storage.Expire(store, time.Now(), false)
+			if err != nil {
+				if !errors.Is(err, storage.ErrLocked) {
+					logger.Printf("expire history error: %v\n", err)
+				}
 				continue
 			}
-			if txt == lastSeen {
-				continue // no change
+			if len(expired) > 0 {
+				logger.Printf("expired %d entries\n", len(expired))
 			}
-
-			clipData, err := storage.LoadClipboardData()
-			if err != nil {
-				logger.Printf("load history error: %v\n", err)
+		case _, ok := <-changes:
+			if ok {
+				check(true)
 				continue
 			}
-
-			// Check if already exists anywhere in history
-			existsAt := -1
-			for i, item := range clipData.History {
-				if item == txt {
-					existsAt = i
-					break
-				}
+			select {
+			case <-stopCh:
+			default:
+				logger.Printf("lost the X server connection, polling every %dms\n", cfg.PollMS)
 			}
-
-			if existsAt == 0 {
-				// Already at top, no change needed
-				lastSeen = txt
-				continue
+			changes, pollClipboard, tick = nil, true, ticker.C
+		case <-tick:
+			if pollClipboard {
+				check(false)
 			}
-
-			if existsAt > 0 {
-				// Remove from old position
-				clipData.History = append(clipData.History[:existsAt], clipData.History[existsAt+1:]...)
+			if pollPrimary {
+				checkPrimary()
 			}
+		}
+	}
+}
 
-			// Add to front
-			clipData.History = append([]string{txt}, clipData.History...)
-			if len(clipData.History) > storage.MaxHistory {
-				clipData.History = clipData.History[:storage.MaxHistory]
-			}
+// watchClipboard subscribes to changes of the X11 clipboard. Under Wayland
+// XFixes only sees copies made by X clients, so the daemon polls there.
+func watchClipboard(stopCh <-chan struct{}) (<-chan string, error) {
+	if os.Getenv("WAYLAND_DISPLAY") != "" {
+		return nil, errors.New("running under Wayland")
+	}
+	return x11.Watch([]string{"CLIPBOARD"}, stopCh)
+}
 
-			if err := storage.SaveClipboardData(clipData); err != nil {
-				logger.Printf("save history error: %v\n", err)
-				continue
+// Content Capture refuses to record.
+var (
+	// ErrBlank is returned for empty or whitespace-only content
+	ErrBlank = errors.New("clipboard empty or whitespace")
+	// ErrOversize is returned for content larger than cfg.MaxEntryBytes
+	// when oversize_policy is "skip"
+	ErrOversize = errors.New("content exceeds max_entry_bytes")
+	// ErrSecret is returned for secrets when secrets.policy is "skip"
+	ErrSecret = errors.New("content is a secret")
+	// ErrIgnoredApp is returned for content copied in an application
+	// listed in secrets.ignore_apps
+	ErrIgnoredApp = errors.New("copied in an ignored application")
+)
+
+// selectionGrowthWindow is how soon after the last PRIMARY capture a
+// selection that extends it is taken for the same selection still growing.
+const selectionGrowthWindow = 5 * time.Second
+
+// Capture records e, content read from e.Selection, as the most recent
+// history entry following the daemon's rules: content already at the top is
+// left alone, content further down is moved to the top (keeping its pin)
+// and new content is added and the history trimmed. A PRIMARY selection
+// that grows while it is being made replaces the entry of its previous
+// state. It reports whether the history changed and its new
+// length.
+//
+// Nothing copied in an application of cfg.Secrets.IgnoreApps is recorded.
+// Text the detectors recognise as a secret has e.Secret set, as do callers
+// for passwords, and is skipped or recorded as a sensitive entry as
+// cfg.Secrets.Policy says.
+func Capture(store storage.Store, cfg *config.Config, e *storage.Entry) (captured bool, histLen int, err error) {
+	if !e.IsBinary() && strings.TrimSpace(e.Content) == "" {
+		return false, 0, ErrBlank
+	}
+	if cfg.MaxEntryBytes > 0 && len(e.Content) > cfg.MaxEntryBytes && cfg.OversizePolicy == storage.OversizeSkip {
+		return false, 0, ErrOversize
+	}
+	if app := ignoredApp(cfg.Secrets.IgnoreApps); app != "" {
+		return false, 0, fmt.Errorf("%w %s", ErrIgnoredApp, app)
+	}
+	if e.Secret == "" && !e.IsBinary() {
+		e.Secret = storage.DetectSecret(e.Content)
+	}
+	if e.Sensitive() && cfg.Secrets.Policy == config.SecretsSkip {
+		return false, 0, fmt.Errorf("%w (%s)", ErrSecret, e.Secret)
+	}
+	if e.Selection == storage.SelectionClipboard {
+		e.Selection = "" // the default
+	}
+	err = store.Update(func(clipData *storage.ClipboardData) error {
+		// Move existing entries to the top instead of duplicating them
+		if i := clipData.Find(e.Content); i == 0 {
+			// Already at top, no change needed
+			return storage.ErrNoChange
+		}
+		now := time.Now()
+		if e.Selection == storage.SelectionPrimary && len(clipData.History) > 0 &&
+			growing(clipData.History[0], e.Content, now) && !clipData.IsPinned(clipData.History[0].ID) {
+			clipData.Remove(0)
+		}
+		clipData.Put(*e, now)
+		histLen = len(clipData.History)
+		captured = true
+		return nil
+	})
+	return captured, histLen, err
+}
+
+// ignoredApp returns the name of the focused application if it is one of
+// apps, or "".
+func ignoredApp(apps []string) string {
+	if len(apps) == 0 {
+		return ""
+	}
+	for _, name := range clipboardPkg.ActiveApp() {
+		for _, app := range apps {
+			if strings.EqualFold(name, app) {
+				return name
 			}
-			lastSeen = txt
-			logger.Printf("captured clipboard (len=%d) preview: %q\n", len(clipData.History), storage.Preview(txt, 80))
 		}
 	}
+	return ""
 }
 
+// growing reports whether txt is top, the entry created by the previous
+// PRIMARY capture moments ago, extended at one end. Entries captured
+// before, and selections that shrink, are never taken for a growing one.
+func growing(top storage.Entry, txt string, now time.Time) bool {
+	if top.Selection != storage.SelectionPrimary || top.Blob != "" || now.Sub(top.LastSeen) > selectionGrowthWindow {
+		return false
+	}
+	// An entry seen or used again is not a selection in progress; fresh
+	// captures have no uses yet
+	if !top.FirstSeen.Equal(top.LastSeen) || top.UseCount != 0 {
+		return false
+	}
+	return len(txt) > len(top.Content) && (strings.HasPrefix(txt, top.Content) || strings.HasSuffix(txt, top.Content))
+}
+
+// encryptedHistory reports whether fs holds an encrypted history, or may:
+// content is kept out of the log when in doubt.
+func encryptedHistory(fs *storage.FileStore) bool {
+	encrypted, err := fs.Encrypted()
+	return encrypted || err != nil
+}
+
+// quarantine moves a corrupt history file aside and replaces it with what
+// can be salvaged, so capturing can continue.
+func quarantine(store storage.Store, logger *log.Logger) {
+	fs, ok := store.(*storage.FileStore)
+	if !ok {
+		return
+	}
+	report, err := fs.Fsck(false)
+	if err != nil {
+		logger.Printf("history repair failed: %v\n", err)
+		return
+	}
+	logger.Printf("history file was corrupt: moved to %s, salvaged %d entries\n", report.Quarantined, report.Salvaged)
+}
+
+// backup takes a backup of the history unless it is unchanged or locked.
+func backup(fs *storage.FileStore, logger *log.Logger) {
+	info, created, err := fs.Backup(time.Now())
+	if err != nil {
+		if !errors.Is(err, storage.ErrLocked) {
+			logger.Printf("backup error: %v\n", err)
+		}
+		return
+	}
+	if created {
+		logger.Printf("backup %s written\n", info.Name)
+	}
+}
@@ -0,0 +1,83 @@
+package daemon
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github/phaneendra24/goclipboard-manager/config"
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+func TestGrowing(t *testing.T) {
+	now := time.Now()
+	fresh := func(content string, age time.Duration) storage.Entry {
+		e := storage.NewEntry(content, storage.SourceDaemon, now.Add(-age))
+		e.Selection = storage.SelectionPrimary
+		return e
+	}
+	seenAgain := fresh("foo", time.Second)
+	seenAgain.FirstSeen = now.Add(-time.Hour)
+	used := fresh("foo", time.Second)
+	used.UseCount = 2
+	clipboard := fresh("foo", time.Second)
+	clipboard.Selection = ""
+	blob := fresh("foo", time.Second)
+	blob.Blob = "0123abcd"
+
+	tests := []struct {
+		name string
+		top  storage.Entry
+		txt  string
+		want bool
+	}{
+		{"extended at the end", fresh("foo", time.Second), "foobar", true},
+		{"extended at the start", fresh("bar", time.Second), "foobar", true},
+		{"extended by one character", fresh("s", 100*time.Millisecond), "se", true},
+		{"shrunk", fresh("foobar", time.Second), "foo", false},
+		{"unchanged", fresh("foo", time.Second), "foo", false},
+		{"extended at both ends", fresh("oob", time.Second), "foobar", false},
+		{"unrelated", fresh("foo", time.Second), "bar", false},
+		{"too old", fresh("foo", 10*time.Second), "foobar", false},
+		{"moved up again", seenAgain, "foobar", false},
+		{"used", used, "foobar", false},
+		{"from the clipboard", clipboard, "foobar", false},
+		{"in a blob", blob, "foobar", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := growing(tt.top, tt.txt, now); got != tt.want {
+				t.Errorf("growing(%q, %q) = %v, want %v", tt.top.Content, tt.txt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCaptureKeepsReselectedEntry(t *testing.T) {
+	store := storage.NewMemoryStore()
+	cfg := config.DefaultConfig()
+	capture := func(txt, selection string) {
+		t.Helper()
+		if _, _, err := Capture(store, cfg, &storage.Entry{Content: txt, Selection: selection}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	capture("f", storage.SelectionPrimary)
+	capture("foo", storage.SelectionPrimary) // the same selection growing
+	capture("other", storage.SelectionClipboard)
+	capture("foo", storage.SelectionPrimary) // selected again
+	capture("foobar", storage.SelectionPrimary)
+
+	entries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Content)
+	}
+	want := []string{"foobar", "foo", "other"}
+	if !slices.Equal(got, want) {
+		t.Errorf("history %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,94 @@
+package daemon
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github/phaneendra24/goclipboard-manager/config"
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// wlPasteRestartDelay is how long the daemon waits before restarting a
+// wl-paste --watch that exited.
+const wlPasteRestartDelay = 2 * time.Second
+
+// wlPasteArgs returns the arguments of the wl-paste --watch command that
+// runs clipcli store for the current profile on every change of selection.
+func wlPasteArgs(selection string) ([]string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"--watch", exe, "--profile", storage.Profile, "store"}
+	if selection == storage.SelectionPrimary {
+		args = append([]string{"--primary"}, append(args, "--selection", selection)...)
+	}
+	return args, nil
+}
+
+// superviseWLPaste starts wl-paste --watch for CLIPBOARD, and for PRIMARY
+// when cfg captures or syncs it, and keeps them running until stopCh is
+// closed. Only the first start is reported as an error; later failures are
+// logged and retried.
+func superviseWLPaste(cfg *config.Config, logger *log.Logger, stopCh <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	selections := []string{storage.SelectionClipboard}
+	if cfg.Capture.WatchesPrimary() {
+		selections = append(selections, storage.SelectionPrimary)
+	}
+	for _, selection := range selections {
+		args, err := wlPasteArgs(selection)
+		if err == nil {
+			err = supervise(ctx, logger, "wl-paste", args)
+		}
+		if err != nil {
+			cancel()
+			return err
+		}
+	}
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+	return nil
+}
+
+// supervise starts a command and restarts it whenever it exits, until ctx
+// is cancelled, which also kills it. Its error output goes to the log.
+func supervise(ctx context.Context, logger *log.Logger, name string, args []string) error {
+	desc := name + " " + strings.Join(args[:1], " ")
+	start := func() (*exec.Cmd, error) {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stderr = logger.Writer()
+		return cmd, cmd.Start()
+	}
+	cmd, err := start()
+	if err != nil {
+		return err
+	}
+	go func() {
+		for {
+			err := cmd.Wait()
+			if ctx.Err() != nil {
+				return
+			}
+			logger.Printf("%s exited (%v), restarting\n", desc, err)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(wlPasteRestartDelay):
+				}
+				if cmd, err = start(); err == nil {
+					break
+				}
+				logger.Printf("%s restart failed: %v\n", desc, err)
+			}
+		}
+	}()
+	return nil
+}
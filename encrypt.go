@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github/phaneendra24/goclipboard-manager/agent"
+	"github/phaneendra24/goclipboard-manager/config"
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// passphraseEnv names the environment variable holding the history passphrase.
+const passphraseEnv = "CLIPCLI_PASSPHRASE"
+
+// expandHome resolves a leading "~/" in a configured path.
+func expandHome(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		if home := os.Getenv("HOME"); home != "" {
+			return filepath.Join(home, p[2:])
+		}
+	}
+	return p
+}
+
+// unlockStore loads the key of an encrypted history from the keyfile, the
+// passphrase environment variable or the running daemon's agent, in that
+// order. A key that does not open the history, such as a stale passphrase,
+// is reported as a warning and the next source tried, so commands that do
+// not need the content, like unlock, decrypt and fsck, keep working. A
+// store that stays locked reports storage.ErrLocked when used.
+func unlockStore(cfg *config.Config, store storage.Store) {
+	fs, ok := store.(*storage.FileStore)
+	if !ok {
+		return
+	}
+	warn := func(source string, err error) {
+		fmt.Fprintf(os.Stderr, "warning: cannot unlock history with %s: %v\n", source, err)
+	}
+	encrypted, err := fs.Encrypted()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "warning:", err)
+		return
+	}
+	if !encrypted {
+		return
+	}
+	if cfg.Encryption.Keyfile != "" {
+		data, err := os.ReadFile(expandHome(cfg.Encryption.Keyfile))
+		if err == nil {
+			err = fs.Unlock(data)
+		}
+		if err == nil {
+			return
+		}
+		warn("encryption.keyfile", err)
+	}
+	if pass := os.Getenv(passphraseEnv); pass != "" {
+		err := fs.Unlock([]byte(pass))
+		if err == nil {
+			return
+		}
+		warn(passphraseEnv, err)
+	}
+	key, err := agent.Key()
+	if err == nil {
+		err = fs.SetKey(key)
+	}
+	if err != nil && !errors.Is(err, agent.ErrNotRunning) && !errors.Is(err, storage.ErrLocked) {
+		warn("the daemon's key", err)
+	}
+}
+
+// fileStore returns the store as a FileStore for commands that only make
+// sense for on-disk history.
+func fileStore(store storage.Store) (*storage.FileStore, error) {
+	fs, ok := store.(*storage.FileStore)
+	if !ok {
+		return nil, errors.New("this command needs the json storage backend")
+	}
+	return fs, nil
+}
+
+// readPassphrase prompts on the terminal without echoing the input.
+func readPassphrase(prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	if fi, err := os.Stdin.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+		stty := exec.Command("stty", "-echo")
+		stty.Stdin = os.Stdin
+		if err := stty.Run(); err == nil {
+			defer func() {
+				restore := exec.Command("stty", "echo")
+				restore.Stdin = os.Stdin
+				restore.Run()
+				fmt.Fprintln(os.Stderr)
+			}()
+		}
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadBytes('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	return bytes.TrimRight(line, "\r\n"), nil
+}
+
+// cmdUnlock hands the passphrase to the daemon so every clipcli process can
+// use the encrypted history.
+func cmdUnlock(cfg *config.Config, store storage.Store) error {
+	fs, err := fileStore(store)
+	if err != nil {
+		return err
+	}
+	if encrypted, err := fs.Encrypted(); err != nil {
+		return err
+	} else if !encrypted {
+		return storage.ErrNotEncrypted
+	}
+	params, err := fs.KeyParams()
+	if err != nil {
+		return err
+	}
+	var secret []byte
+	if params.Source == storage.KeySourceKeyfile {
+		if cfg.Encryption.Keyfile == "" {
+			return errors.New("history uses a keyfile but encryption.keyfile is not set")
+		}
+		if secret, err = os.ReadFile(expandHome(cfg.Encryption.Keyfile)); err != nil {
+			return err
+		}
+	} else if secret, err = readPassphrase("Passphrase: "); err != nil {
+		return err
+	}
+	// Verify locally first for a clear error on a mistyped passphrase
+	if err := fs.Unlock(secret); err != nil {
+		return err
+	}
+	if err := agent.Unlock(secret); err != nil {
+		return fmt.Errorf("history key is valid but the daemon could not keep it: %w", err)
+	}
+	fmt.Println("history unlocked")
+	return nil
+}
+
+// cmdLock makes the daemon forget the history key.
+func cmdLock() error {
+	if err := agent.Lock(); err != nil {
+		return err
+	}
+	fmt.Println("history locked")
+	return nil
+}
+
+// cmdEncrypt converts a plaintext history to an encrypted one.
+func cmdEncrypt(cfg *config.Config, store storage.Store) error {
+	fs, err := fileStore(store)
+	if err != nil {
+		return err
+	}
+	source := storage.KeySourcePassphrase
+	var secret []byte
+	if cfg.Encryption.Keyfile != "" {
+		source = storage.KeySourceKeyfile
+		path := expandHome(cfg.Encryption.Keyfile)
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			if err := storage.GenerateKeyfile(path); err != nil {
+				return err
+			}
+			fmt.Printf("generated keyfile %s\n", path)
+		}
+		if secret, err = os.ReadFile(path); err != nil {
+			return err
+		}
+	} else if pass := os.Getenv(passphraseEnv); pass != "" {
+		secret = []byte(pass)
+	} else {
+		if secret, err = readPassphrase("New passphrase: "); err != nil {
+			return err
+		}
+		again, err := readPassphrase("Repeat passphrase: ")
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(secret, again) {
+			return errors.New("passphrases do not match")
+		}
+	}
+	report, err := fs.Encrypt(source, secret)
+	if err != nil {
+		return err
+	}
+	// Let a running daemon keep capturing into the encrypted history
+	if err := agent.Unlock(secret); err != nil && !errors.Is(err, agent.ErrNotRunning) {
+		fmt.Fprintln(os.Stderr, "warning: daemon did not accept the new key:", err)
+	}
+	fmt.Println("history encrypted")
+	printRekeyReport(report, "encrypted")
+	return nil
+}
+
+// cmdDecrypt converts an encrypted history back to plaintext.
+func cmdDecrypt(store storage.Store) error {
+	fs, err := fileStore(store)
+	if err != nil {
+		return err
+	}
+	report, err := fs.Decrypt()
+	if err != nil {
+		return err
+	}
+	// The daemon no longer needs the key
+	if err := agent.Lock(); err != nil && !errors.Is(err, agent.ErrNotRunning) {
+		fmt.Fprintln(os.Stderr, "warning: daemon did not drop the key:", err)
+	}
+	fmt.Println("history decrypted")
+	printRekeyReport(report, "decrypted")
+	return nil
+}
+
+// printRekeyReport tells what was converted along with the history.
+func printRekeyReport(report *storage.RekeyReport, verb string) {
+	if report.Backups > 0 {
+		fmt.Printf("%s %d backups\n", verb, report.Backups)
+	}
+	for _, name := range report.Copies {
+		fmt.Printf("%s %s\n", verb, name)
+	}
+	for _, name := range report.Unreadable {
+		fmt.Fprintf(os.Stderr, "warning: %s cannot be read with the old key and was left as is\n", name)
+	}
+}
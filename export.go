@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github/phaneendra24/goclipboard-manager/config"
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// parseSince reads a --since value: a date ("2024-05-01"), an RFC 3339 time
+// or an age such as "7d" or "12h" counted back from now.
+func parseSince(s string, now time.Time) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if d, err := config.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since %q (want a date, an RFC 3339 time or an age like 7d)", s)
+}
+
+// cmdExport writes the history, or the part of it matching the filters, to
+// stdout or a file in one of storage.ExportFormats.
+func cmdExport(store storage.Store, args []string) error {
+	fset := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fset.String("format", storage.FormatJSON, "output format: "+strings.Join(storage.ExportFormats, ", "))
+	pinned := fset.Bool("pinned", false, "only pinned entries")
+	since := fset.String("since", "", "only entries last used since a date, time or age (7d)")
+	typ := fset.String("type", "", "only entries of this content type (text, url, path)")
+	query := fset.String("query", "", "only entries containing this text (ignoring case)")
+	output := fset.String("output", "", "write to this file instead of stdout")
+	fset.StringVar(output, "o", "", "shorthand for --output")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fset.Arg(0))
+	}
+	if !slices.Contains(storage.ExportFormats, *format) {
+		return fmt.Errorf("unknown export format %q (want one of %s)", *format, strings.Join(storage.ExportFormats, ", "))
+	}
+
+	q := storage.Query{Type: *typ, PinnedOnly: *pinned}
+	if *since != "" {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			return err
+		}
+		q.Since = t
+	}
+	clipData, err := storage.Snapshot(store)
+	if err != nil {
+		return err
+	}
+	needle := strings.ToLower(*query)
+	var recs []storage.ExportRecord
+	images, secrets := 0, 0
+	for _, e := range clipData.History {
+		if !q.Match(e, clipData.IsPinned(e.ID)) {
+			continue
+		}
+		// The export formats hold text only
+		if e.IsBinary() {
+			images++
+			continue
+		}
+		// Secrets do not belong in files
+		if e.Sensitive() {
+			secrets++
+			continue
+		}
+		content, err := store.Content(e)
+		if err != nil {
+			return err
+		}
+		if needle != "" && !strings.Contains(strings.ToLower(content), needle) {
+			continue
+		}
+		recs = append(recs, storage.NewExportRecord(e, content, clipData.IsPinned(e.ID)))
+	}
+	storage.SortExport(recs)
+	if images > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d image entries (export holds text only)\n", images)
+	}
+	if secrets > 0 {
+		fmt.Fprintf(os.Stderr, "skipped %d sensitive entries\n", secrets)
+	}
+
+	if *output != "" {
+		// Clipboard history is private; keep the file to ourselves
+		f, err := os.OpenFile(*output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+		if err != nil {
+			return err
+		}
+		if err := storage.Export(f, *format, recs); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "exported %d entries to %s\n", len(recs), *output)
+		return nil
+	}
+	return storage.Export(os.Stdout, *format, recs)
+}
+
+// cmdImport merges history from an export or history file, or stdin for
+// "-", into the current history or replaces it. With --from it reads the
+// history of another clipboard manager, from its default location unless a
+// file is given.
+func cmdImport(store storage.Store, args []string) error {
+	fset := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := fset.String("format", "", "input format (default: detect): "+strings.Join(storage.ImportFormats, ", "))
+	from := fset.String("from", "", "read another clipboard manager's history: "+strings.Join(storage.ForeignManagers, ", "))
+	replace := fset.Bool("replace", false, "move the current history to the trash first")
+	dryRun := fset.Bool("dry-run", false, "only report what would change")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if *from != "" && *format != "" {
+		return errors.New("--from and --format cannot be combined")
+	}
+	var name string
+	switch {
+	case fset.NArg() == 1:
+		name = fset.Arg(0)
+	case fset.NArg() == 0 && *from != "":
+		path, err := storage.ForeignHistoryPath(*from)
+		if err != nil {
+			return err
+		}
+		name = path
+	default:
+		return errors.New("import requires one file (or - for stdin)")
+	}
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+	var imp *storage.Imported
+	if *from != "" {
+		imp, err = storage.ParseForeign(data, *from)
+	} else {
+		imp, err = storage.ParseImport(data, *format)
+	}
+	if err != nil {
+		return err
+	}
+
+	var report storage.MergeReport
+	run := store.Update
+	verb := "imported"
+	if *dryRun {
+		run, verb = store.View, "would import"
+	}
+	err = run(func(clipData *storage.ClipboardData) error {
+		report = clipData.Import(imp, *replace, time.Now())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %d added, %d updated, %d skipped, %d pinned\n",
+		verb, report.Added, report.Updated, report.Skipped, report.Pinned)
+	if *replace && !*dryRun {
+		fmt.Println("previous history moved to the trash (restore with: clipcli trash restore)")
+	}
+	return nil
+}
@@ -2,6 +2,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -9,66 +10,227 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	clipboardPkg "github/phaneendra24/goclipboard-manager/clipboard"
 	"github/phaneendra24/goclipboard-manager/config"
-	"github/phaneendra24/goclipboard-manager/daemon"
 	"github/phaneendra24/goclipboard-manager/storage"
 	"github/phaneendra24/goclipboard-manager/ui"
 )
 
 func printUsage() {
-	fmt.Println(`Usage: clipcli <command>
+	fmt.Println(`Usage: clipcli [--profile NAME] <command>
+
+The profile defaults to $CLIPCLI_PROFILE, then to the one chosen with
+"clipcli profile use".
 
 Commands:
   serve [poll_ms]   Run daemon (poll_ms optional, default 300)
   save              Save current clipboard to history
-  list              List history previews
-  paste N           Paste history item N (0 = most recent)
-  clear             Clear history
-  gui               Open graphical clipboard manager`)
+  store [--mime TYPE] [--selection clipboard|primary]
+                    Save clipboard content read from stdin (for wl-paste --watch)
+  list [--picker]   List history previews (--picker: "ID<TAB>preview" lines)
+  decode [--copy]   Print (or copy) the entry of a --picker line read from stdin
+  search TEXT       List entries containing TEXT (ignoring case)
+  paste N|ID        Paste history item N (0 = most recent) or by ID prefix
+  clear             Move all history to the trash
+  trash list        List deleted entries
+  trash restore [N|ID...]  Restore deleted entries (default: last deletion)
+  trash empty       Delete the trash for good
+  export [--format json|ndjson|csv|md|raw0] [--pinned] [--since DATE|AGE]
+         [--type TYPE] [--query TEXT] [-o FILE]  Export history
+  import [--format F] [--replace] [--dry-run] FILE|-  Merge exported history
+  import --from clipman|gpaste|klipper [FILE]  Import another manager's history
+  gc [--dry-run]    Remove entries past their retention age
+  fsck [--dry-run]  Check history for damage and repair it
+  backup list       List automatic backups
+  backup now        Take a backup
+  backup restore N|NAME [--merge]  Restore a backup (--merge only adds what is missing)
+  profile [list]    Show the current profile or list all of them
+  profile use NAME  Switch to a profile (the daemon follows)
+  gui               Open graphical clipboard manager
+  encrypt           Encrypt history (passphrase, CLIPCLI_PASSPHRASE or keyfile)
+  decrypt           Decrypt history back to plaintext
+  unlock            Hand the history key to the running daemon
+  lock              Make the running daemon forget the history key`)
 }
 
-func cmdList() error {
-	hist, err := storage.LoadHistory()
+func cmdList(store storage.Store, cfg *config.Config, args []string) error {
+	fset := flag.NewFlagSet("list", flag.ContinueOnError)
+	picker := fset.Bool("picker", false, "print \"ID<TAB>preview\" lines for dmenu-style pickers")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fset.Arg(0))
+	}
+	if *picker {
+		return cmdPicker(store, cfg)
+	}
+	clipData, err := storage.Snapshot(store)
 	if err != nil {
 		return err
 	}
-	if len(hist) == 0 {
+	if len(clipData.History) == 0 {
 		fmt.Println("(history empty)")
 		return nil
 	}
-	for i, entry := range hist {
-		preview := strings.Split(entry, "\n")[0]
-		if len(preview) > 200 {
-			preview = preview[:200] + "…"
+	now := time.Now()
+	for i, entry := range clipData.History {
+		printEntry(i, entry, clipData.IsPinned(entry.ID), now, cfg.Preview.List)
+	}
+	return nil
+}
+
+// printEntry prints one line of list or search output for history item i,
+// previewing up to previewLen bytes of its first line.
+func printEntry(i int, entry storage.Entry, pinned bool, now time.Time, previewLen int) {
+	preview := storage.Preview(strings.Split(entry.DisplayText(), "\n")[0], previewLen)
+	pin := " "
+	if pinned {
+		pin = "*"
+	}
+	fmt.Printf("[%d]%s %s %-4s %-9s %6s used=%d first=%s last=%s  %s\n",
+		i, pin, entry.ID, entry.Type, entry.SelectionName(), storage.FormatSize(entry.Size), entry.UseCount,
+		storage.FormatAge(entry.FirstSeen, now), storage.FormatAge(entry.LastSeen, now), preview)
+}
+
+// cmdSearch lists the entries whose full content contains query, ignoring
+// case. The search index narrows down which entries have to be read.
+func cmdSearch(store storage.Store, cfg *config.Config, query string) error {
+	index, err := storage.OpenIndex(store)
+	if err != nil {
+		return err
+	}
+	candidates, narrowed := index.Candidates(query)
+	clipData, err := storage.Snapshot(store)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	needle := strings.ToLower(query)
+	found := 0
+	for i, entry := range clipData.History {
+		if narrowed && !candidates[entry.ID] {
+			continue
 		}
-		fmt.Printf("[%d] %s\n", i, preview)
+		// Binary content is searched by its description, secrets by their
+		// mask
+		content := entry.DisplayText()
+		if !entry.IsBinary() && !entry.Sensitive() {
+			if content, err = store.Content(entry); err != nil {
+				return err
+			}
+		}
+		if !strings.Contains(strings.ToLower(content), needle) {
+			continue
+		}
+		printEntry(i, entry, clipData.IsPinned(entry.ID), now, cfg.Preview.List)
+		found++
+	}
+	if found == 0 {
+		fmt.Println("(no matches)")
+	}
+	return nil
+}
+
+func cmdGC(store storage.Store, cfg *config.Config, dryRun bool) error {
+	now := time.Now()
+	expired, err := storage.Expire(store, now, dryRun)
+	if err != nil {
+		return err
+	}
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+	for _, entry := range expired {
+		fmt.Printf("%s %s %-4s last=%s  %s\n", verb, entry.ID, entry.Type,
+			storage.FormatAge(entry.LastSeen, now), storage.Preview(strings.Split(entry.DisplayText(), "\n")[0], cfg.Preview.Log))
+	}
+	fmt.Printf("%s %d expired entries\n", verb, len(expired))
+	if dryRun {
+		return nil
+	}
+	// Also drop blobs left behind by interrupted writes
+	if fs, ok := store.(*storage.FileStore); ok {
+		return fs.Compact()
 	}
 	return nil
 }
 
+func cmdFsck(store storage.Store, dryRun bool) (bool, error) {
+	fs, err := fileStore(store)
+	if err != nil {
+		return false, err
+	}
+	report, err := fs.Fsck(dryRun)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range report.Problems {
+		fmt.Println("problem:", p)
+	}
+	if report.Corrupt {
+		fmt.Printf("salvaged %d entries from the damaged history file\n", report.Salvaged)
+	}
+	switch {
+	case report.OK():
+		fmt.Printf("history ok (%d entries)\n", report.Entries)
+	case dryRun:
+		fmt.Println("run clipcli fsck without --dry-run to repair")
+	default:
+		if report.Quarantined != "" {
+			fmt.Printf("moved damaged file to %s\n", report.Quarantined)
+		}
+		fmt.Printf("history repaired (%d entries)\n", report.Entries)
+	}
+	return report.OK(), nil
+}
+
 func main() {
-	// Configure logging to file (if possible) else stdout
+	// Configure logging to file (if possible) else stdout. The log
+	// previews captured content, so only the user may read it, including
+	// logs created with looser permissions before.
 	logPath, _ := storage.LogFilePath()
-	logf, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	logf, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
 	if err != nil {
 		log.SetOutput(os.Stdout)
 	} else {
+		logf.Chmod(0o600)
 		log.SetOutput(logf)
 		defer logf.Close()
 	}
 	logger := log.Default()
 
+	args, profile, explicit, err := takeProfileFlag(os.Args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+	os.Args = args
 	if len(os.Args) < 2 {
 		printUsage()
 		os.Exit(1)
 	}
 
+	storage.Profile = profile
+	cfg, err := config.Load(profile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "config error:", err)
+		os.Exit(2)
+	}
+	storage.Configure(cfg)
+	store, err := storage.Open(cfg.Storage.Backend)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(2)
+	}
+	unlockStore(cfg, store)
+
 	switch os.Args[1] {
 	case "serve":
-		cfg, _ := config.Load()
-		pollMS := cfg.PollMS
+		pollMS := 0 // use the profile's poll_ms
 		if len(os.Args) >= 3 {
 			if v, err := strconv.Atoi(os.Args[2]); err == nil && v > 0 {
 				pollMS = v
@@ -83,51 +245,153 @@ func main() {
 			logger.Printf("received signal %v, shutting down\n", sig)
 			close(stop)
 		}()
-		if err := daemon.Run(pollMS, logger, stop); err != nil {
+		if err := serve(cfg, store, pollMS, !explicit, logger, stop); err != nil {
 			logger.Fatalf("daemon error: %v\n", err)
 		}
 
 	case "save":
-		if err := clipboardPkg.Save(); err != nil {
+		if err := cmdSave(store, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "store":
+		if err := cmdStore(store, cfg, os.Args[2:]); err != nil {
 			fmt.Fprintln(os.Stderr, "error:", err)
 			os.Exit(2)
 		}
 
 	case "list":
-		if err := cmdList(); err != nil {
+		if err := cmdList(store, cfg, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "decode":
+		if err := cmdDecode(store, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "search":
+		if len(os.Args) < 3 {
+			fmt.Fprintln(os.Stderr, "search requires text")
+			os.Exit(2)
+		}
+		if err := cmdSearch(store, cfg, strings.Join(os.Args[2:], " ")); err != nil {
 			fmt.Fprintln(os.Stderr, "error:", err)
 			os.Exit(2)
 		}
 
 	case "paste":
 		if len(os.Args) < 3 {
-			fmt.Fprintln(os.Stderr, "paste requires index")
+			fmt.Fprintln(os.Stderr, "paste requires index or ID")
 			os.Exit(2)
 		}
-		i, err := strconv.Atoi(os.Args[2])
+		entry, err := clipboardPkg.PasteByRef(store, os.Args[2], cfg.Paste)
 		if err != nil {
-			fmt.Fprintln(os.Stderr, "invalid index")
+			fmt.Fprintln(os.Stderr, "error:", err)
 			os.Exit(2)
 		}
-		if err := clipboardPkg.PasteByIndex(i); err != nil {
+		fmt.Printf("pasted %s\n", entry.ID)
+
+	case "clear":
+		err := store.Update(func(clipData *storage.ClipboardData) error {
+			clipData.DiscardAll(time.Now())
+			return nil
+		})
+		if err != nil {
 			fmt.Fprintln(os.Stderr, "error:", err)
 			os.Exit(2)
 		}
-		fmt.Printf("pasted index %d\n", i)
+		fmt.Println("history cleared (restore with: clipcli trash restore)")
 
-	case "clear":
-		if err := storage.SaveHistory([]string{}); err != nil {
+	case "export":
+		if err := cmdExport(store, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "import":
+		if err := cmdImport(store, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "gc":
+		dryRun := len(os.Args) >= 3 && os.Args[2] == "--dry-run"
+		if err := cmdGC(store, cfg, dryRun); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "trash":
+		if err := cmdTrash(store, cfg, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "fsck":
+		dryRun := len(os.Args) >= 3 && os.Args[2] == "--dry-run"
+		ok, err := cmdFsck(store, dryRun)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+		if !ok && dryRun {
+			os.Exit(1)
+		}
+
+	case "backup":
+		if err := cmdBackup(store, os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "profile":
+		if err := cmdProfile(os.Args[2:]); err != nil {
 			fmt.Fprintln(os.Stderr, "error:", err)
 			os.Exit(2)
 		}
-		fmt.Println("history cleared")
 
 	case "gui":
-		if err := ui.RunGUI(); err != nil {
+		// Switching profiles in the GUI also switches the daemon
+		switchProfile := func(name string) (*config.Config, storage.Store, error) {
+			cfg, store, err := openProfile(name)
+			if err != nil {
+				return nil, nil, err
+			}
+			return cfg, store, storage.SetActiveProfile(name)
+		}
+		if err := ui.RunGUI(store, cfg, switchProfile); err != nil {
 			fmt.Fprintln(os.Stderr, "gui error:", err)
 			os.Exit(2)
 		}
 
+	case "encrypt":
+		if err := cmdEncrypt(cfg, store); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "decrypt":
+		if err := cmdDecrypt(store); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "unlock":
+		if err := cmdUnlock(cfg, store); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
+	case "lock":
+		if err := cmdLock(); err != nil {
+			fmt.Fprintln(os.Stderr, "error:", err)
+			os.Exit(2)
+		}
+
 	default:
 		printUsage()
 		os.Exit(1)
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	clipboardPkg "github/phaneendra24/goclipboard-manager/clipboard"
+	"github/phaneendra24/goclipboard-manager/config"
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// pickerEscaper keeps a picker line on one line and its fields apart.
+var pickerEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// pickerLine formats an entry for dmenu-style pickers: its ID, a tab and
+// its content escaped onto one line, cut to about previewLen bytes.
+func pickerLine(entry storage.Entry, previewLen int) string {
+	preview := pickerEscaper.Replace(entry.DisplayText())
+	if len(preview) > previewLen {
+		cut := previewLen
+		for cut > 0 && !utf8.RuneStart(preview[cut]) {
+			cut--
+		}
+		preview = preview[:cut] + "…"
+	}
+	return entry.ID + "\t" + preview
+}
+
+// cmdPicker prints a picker line per history entry, most recent first.
+func cmdPicker(store storage.Store, cfg *config.Config) error {
+	clipData, err := storage.Snapshot(store)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(os.Stdout)
+	for _, entry := range clipData.History {
+		fmt.Fprintln(w, pickerLine(entry, cfg.Preview.List))
+	}
+	return w.Flush()
+}
+
+// cmdDecode reads a line chosen from list --picker output on stdin and
+// prints the full content of its entry, or with --copy puts it on the
+// clipboard.
+func cmdDecode(store storage.Store, args []string) error {
+	fset := flag.NewFlagSet("decode", flag.ContinueOnError)
+	copyIt := fset.Bool("copy", false, "copy the entry to the clipboard instead of printing it")
+	if err := fset.Parse(args); err != nil {
+		return err
+	}
+	if fset.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fset.Arg(0))
+	}
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	id, _, _ := strings.Cut(strings.TrimSpace(line), "\t")
+	if id == "" {
+		return errors.New("no entry selected")
+	}
+	// Only accept full IDs, which never look like an index
+	if len(id) != storage.IDLength {
+		return fmt.Errorf("invalid picker line: %q does not start with an entry ID", id)
+	}
+	entry, err := store.Get(id)
+	if errors.Is(err, storage.ErrNotFound) {
+		return fmt.Errorf("entry %s is no longer in the history", id)
+	}
+	if err != nil {
+		return err
+	}
+	content, err := store.Content(entry)
+	if err != nil {
+		return err
+	}
+	if !*copyIt {
+		_, err := io.WriteString(os.Stdout, content)
+		return err
+	}
+	if err := clipboardPkg.CopyEntry(entry, content); err != nil {
+		return fmt.Errorf("write clipboard: %w", err)
+	}
+	return store.Update(func(clipData *storage.ClipboardData) error {
+		if i := clipData.IndexOf(entry.ID); i >= 0 {
+			clipData.MarkUsed(i)
+		}
+		return nil
+	})
+}
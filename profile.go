@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github/phaneendra24/goclipboard-manager/agent"
+	"github/phaneendra24/goclipboard-manager/config"
+	"github/phaneendra24/goclipboard-manager/daemon"
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// profileEnv names the environment variable selecting the profile.
+const profileEnv = "CLIPCLI_PROFILE"
+
+// profileCheckInterval is how often the daemon looks for a profile switch.
+const profileCheckInterval = time.Second
+
+// takeProfileFlag removes a leading --profile NAME (or --profile=NAME) from
+// args and returns the profile to use: the flag, $CLIPCLI_PROFILE or the
+// one selected with `clipcli profile use`, in that order. explicit reports
+// whether the flag or the environment chose it.
+func takeProfileFlag(args []string) (rest []string, profile string, explicit bool, err error) {
+	rest = args
+	if len(rest) > 1 {
+		switch arg := rest[1]; {
+		case arg == "--profile":
+			if len(rest) < 3 {
+				return nil, "", false, errors.New("--profile requires a name")
+			}
+			profile = rest[2]
+			rest = append(rest[:1:1], rest[3:]...)
+		case strings.HasPrefix(arg, "--profile="):
+			profile = strings.TrimPrefix(arg, "--profile=")
+			rest = append(rest[:1:1], rest[2:]...)
+		}
+	}
+	if profile == "" {
+		profile = os.Getenv(profileEnv)
+	}
+	if profile != "" {
+		return rest, profile, true, storage.ValidateProfile(profile)
+	}
+	profile, err = storage.ActiveProfile()
+	return rest, profile, false, err
+}
+
+// openProfile loads the configuration and history of the named profile and
+// makes it the current one. On failure the current profile is unchanged.
+func openProfile(name string) (*config.Config, storage.Store, error) {
+	cfg, err := config.Load(name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("profile %s: config error: %w", name, err)
+	}
+	prev := storage.Profile
+	storage.Profile = name
+	store, err := storage.Open(cfg.Storage.Backend)
+	if err != nil {
+		storage.Profile = prev
+		return nil, nil, fmt.Errorf("profile %s: %w", name, err)
+	}
+	unlockStore(cfg, store)
+	storage.Configure(cfg)
+	return cfg, store, nil
+}
+
+// cmdProfile shows, lists or switches profiles.
+func cmdProfile(args []string) error {
+	if len(args) == 0 {
+		fmt.Println(storage.Profile)
+		return nil
+	}
+	switch args[0] {
+	case "list":
+		profiles, err := storage.Profiles()
+		if err != nil {
+			return err
+		}
+		active, err := storage.ActiveProfile()
+		if err != nil {
+			return err
+		}
+		for _, name := range profiles {
+			mark := " "
+			if name == active {
+				mark = "*"
+			}
+			fmt.Printf("%s %s\n", mark, name)
+		}
+		return nil
+
+	case "use":
+		if len(args) < 2 {
+			return errors.New("profile use requires a name")
+		}
+		if err := storage.SetActiveProfile(args[1]); err != nil {
+			return err
+		}
+		fmt.Printf("switched to profile %s\n", args[1])
+		if env := os.Getenv(profileEnv); env != "" && env != args[1] {
+			fmt.Printf("note: %s=%s still overrides it in this shell\n", profileEnv, env)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown profile command %q", args[0])
+	}
+}
+
+// serve runs the daemon on store until stop is closed, polling every pollMS
+// milliseconds or, if that is zero, as often as the profile's config says.
+// Unless the profile was chosen explicitly (follow is false) it switches to
+// whichever profile `clipcli profile use` makes active.
+func serve(cfg *config.Config, store storage.Store, pollMS int, follow bool, logger *log.Logger, stop <-chan struct{}) error {
+	// A nil channel never fires, which pins the profile
+	var checkC <-chan time.Time
+	if follow {
+		check := time.NewTicker(profileCheckInterval)
+		defer check.Stop()
+		checkC = check.C
+	}
+	failed := "" // profile that could not be opened, not retried until changed
+	for {
+		daemonCfg := *cfg
+		if pollMS > 0 {
+			daemonCfg.PollMS = pollMS
+		}
+		logger.Printf("serving profile %s\n", storage.Profile)
+		// Keep the history key in memory for other clipcli processes
+		var srv *agent.Server
+		if fs, ok := store.(*storage.FileStore); ok {
+			var err error
+			if srv, err = agent.Listen(fs, logger); err != nil {
+				return fmt.Errorf("agent: %w", err)
+			}
+			go srv.Serve()
+		}
+		runStop := make(chan struct{})
+		done := make(chan error, 1)
+		go func() { done <- daemon.Run(store, &daemonCfg, logger, runStop) }()
+
+		next := ""
+	wait:
+		for {
+			select {
+			case <-stop:
+				break wait
+			case err := <-done:
+				if srv != nil {
+					srv.Close()
+				}
+				return err
+			case <-checkC:
+				name, err := storage.ActiveProfile()
+				if err != nil || name == failed {
+					continue
+				}
+				if name == storage.Profile {
+					failed = ""
+					continue
+				}
+				next = name
+				break wait
+			}
+		}
+		close(runStop)
+		err := <-done
+		if srv != nil {
+			srv.Close()
+		}
+		if err != nil || next == "" {
+			return err
+		}
+
+		logger.Printf("switching to profile %s\n", next)
+		nextCfg, nextStore, err := openProfile(next)
+		if err != nil {
+			logger.Printf("%v, staying on profile %s\n", err, storage.Profile)
+			failed = next
+			continue
+		}
+		cfg, store, failed = nextCfg, nextStore, ""
+	}
+}
@@ -0,0 +1,365 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupDirName is the data subdirectory holding history backups.
+const BackupDirName = "backups"
+
+// backupExt is the file extension of a backup: gzip-compressed history JSON,
+// sealed when the history is encrypted.
+const backupExt = ".json.gz"
+
+// backupTimeLayout names backups by the local time they were taken.
+const backupTimeLayout = "20060102-150405"
+
+// BackupPolicy controls automatic backups.
+type BackupPolicy struct {
+	Interval time.Duration // how often the daemon takes a backup; 0 disables
+	Daily    int           // keep the newest backup of this many recent days
+	Weekly   int           // keep the newest backup of this many recent weeks
+}
+
+// Backups is the policy for automatic backups (configurable).
+var Backups = BackupPolicy{Interval: time.Hour, Daily: 7, Weekly: 4}
+
+// BackupInfo describes a backup file.
+type BackupInfo struct {
+	Name string // file name without extension, "<time>-<checksum>"
+	Time time.Time
+	Size int64
+}
+
+// checksum returns the content checksum embedded in the backup's name.
+func (b BackupInfo) checksum() string {
+	return b.Name[strings.LastIndexByte(b.Name, '-')+1:]
+}
+
+// Backup writes the current history, with the full content of blob
+// entries, to a new file in the backups directory and prunes old backups
+// according to Backups. Secrets are left out since backups are kept far
+// longer than their retention. If the history is unchanged since the latest
+// backup nothing is written and created is false.
+func (s *FileStore) Backup(now time.Time) (info BackupInfo, created bool, err error) {
+	lock, err := acquireLock(s.dir, false, LockTimeout)
+	if err != nil {
+		return info, false, err
+	}
+	defer lock.release()
+
+	c, err := s.activeCipher()
+	if err != nil {
+		return info, false, err
+	}
+	clipData, _, err := loadState(s.dir, c)
+	if err != nil {
+		return info, false, err
+	}
+	clipData.dropSecrets()
+	if err := inlineBlobs(s.dir, clipData, c); err != nil {
+		return info, false, err
+	}
+	data, err := encodeSnapshot(clipData, 0)
+	if err != nil {
+		return info, false, err
+	}
+	sum := blobName(string(data), c)[:8]
+
+	existing, err := ListBackups(s.dir)
+	if err != nil {
+		return info, false, err
+	}
+	if len(existing) > 0 && existing[0].checksum() == sum {
+		return existing[0], false, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return info, false, err
+	}
+	if err := zw.Close(); err != nil {
+		return info, false, err
+	}
+	data = buf.Bytes()
+	if c != nil {
+		data = sealFile(c, data)
+	}
+	info = BackupInfo{Name: now.Format(backupTimeLayout) + "-" + sum, Time: now, Size: int64(len(data))}
+	dir := filepath.Join(s.dir, BackupDirName)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return info, false, err
+	}
+	if err := writeFileAtomic(filepath.Join(dir, info.Name+backupExt), data); err != nil {
+		return info, false, err
+	}
+	return info, true, pruneBackups(s.dir, Backups)
+}
+
+// inlineBlobs replaces the previews of blob entries with their content so
+// the data no longer depends on the blob store.
+func inlineBlobs(dir string, clipData *ClipboardData, c *Cipher) error {
+	for i, e := range clipData.History {
+		if e.Blob == "" {
+			continue
+		}
+		content, err := readBlob(dir, e.Blob, c)
+		if err != nil {
+			return err
+		}
+		clipData.History[i].Content = content
+		clipData.History[i].Blob = ""
+	}
+	for i, t := range clipData.Trash {
+		if t.Blob == "" {
+			continue
+		}
+		content, err := readBlob(dir, t.Blob, c)
+		if err != nil {
+			return err
+		}
+		clipData.Trash[i].Content = content
+		clipData.Trash[i].Blob = ""
+	}
+	return nil
+}
+
+// rekeyBackups rewrites every backup in dir from cipher from to cipher to,
+// renaming it for the checksum under the new key. It returns how many it
+// converted and the names of those from cannot open, which are left alone.
+func rekeyBackups(dir string, from, to *Cipher) (n int, unreadable []string, err error) {
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+	for _, b := range backups {
+		p := filepath.Join(dir, BackupDirName, b.Name+backupExt)
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			return n, unreadable, err
+		}
+		compressed, err := openFile(from, raw)
+		if err != nil {
+			unreadable = append(unreadable, b.Name)
+			continue
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			unreadable = append(unreadable, b.Name)
+			continue
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			unreadable = append(unreadable, b.Name)
+			continue
+		}
+		if to != nil {
+			compressed = sealFile(to, compressed)
+		}
+		name := b.Name[:strings.LastIndexByte(b.Name, '-')+1] + blobName(string(data), to)[:8]
+		if err := writeFileAtomic(filepath.Join(dir, BackupDirName, name+backupExt), compressed); err != nil {
+			return n, unreadable, err
+		}
+		if name != b.Name {
+			if err := os.Remove(p); err != nil {
+				return n, unreadable, err
+			}
+		}
+		n++
+	}
+	return n, unreadable, nil
+}
+
+// ListBackups returns the backups in dir, newest first.
+func ListBackups(dir string) ([]BackupInfo, error) {
+	names, err := os.ReadDir(filepath.Join(dir, BackupDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var backups []BackupInfo
+	for _, de := range names {
+		name, ok := strings.CutSuffix(de.Name(), backupExt)
+		if !ok || strings.HasPrefix(name, ".") {
+			continue
+		}
+		i := strings.LastIndexByte(name, '-')
+		if i < 0 {
+			continue
+		}
+		t, err := time.ParseInLocation(backupTimeLayout, name[:i], time.Local)
+		if err != nil {
+			continue
+		}
+		fi, err := de.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupInfo{Name: name, Time: t, Size: fi.Size()})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Name > backups[j].Name
+	})
+	return backups, nil
+}
+
+// pruneBackups keeps the newest backup overall plus the newest one of each
+// of the p.Daily most recent days and p.Weekly most recent weeks that have
+// backups, and deletes the rest.
+func pruneBackups(dir string, p BackupPolicy) error {
+	backups, err := ListBackups(dir)
+	if err != nil || len(backups) == 0 {
+		return err
+	}
+	keep := map[string]bool{backups[0].Name: true}
+	days := make(map[string]bool)
+	weeks := make(map[string]bool)
+	for _, b := range backups {
+		day := b.Time.Format("2006-01-02")
+		if !days[day] && len(days) < p.Daily {
+			days[day] = true
+			keep[b.Name] = true
+		}
+		year, week := b.Time.ISOWeek()
+		wk := fmt.Sprintf("%d-%02d", year, week)
+		if !weeks[wk] && len(weeks) < p.Weekly {
+			weeks[wk] = true
+			keep[b.Name] = true
+		}
+	}
+	for _, b := range backups {
+		if keep[b.Name] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, BackupDirName, b.Name+backupExt)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// ResolveBackup finds the backup a reference points at: an index into
+// ListBackups (0 = newest) or a prefix of its name.
+func (s *FileStore) ResolveBackup(ref string) (BackupInfo, error) {
+	backups, err := ListBackups(s.dir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	i, err := resolveRef(ref, len(backups), func(i int) string { return backups[i].Name })
+	if err != nil {
+		return BackupInfo{}, fmt.Errorf("backup %s: %w", ref, err)
+	}
+	return backups[i], nil
+}
+
+// ReadBackup loads the clipboard data stored in a backup. Backups written
+// by older versions are migrated like the history file.
+func (s *FileStore) ReadBackup(b BackupInfo) (*ClipboardData, error) {
+	c, err := s.activeCipher()
+	if err != nil {
+		return nil, err
+	}
+	p := filepath.Join(s.dir, BackupDirName, b.Name+backupExt)
+	raw, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	if raw, err = openFile(c, raw); err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(raw))
+	if err != nil {
+		return nil, &CorruptError{Path: p, Err: err}
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, &CorruptError{Path: p, Err: err}
+	}
+	doc, err := parseHistoryDoc(data)
+	if err != nil {
+		return nil, &CorruptError{Path: p, Err: err}
+	}
+	if err := migrate(doc, b.Time); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return decodeClipboardData(doc)
+}
+
+// RestoreBackup brings back the history stored in a backup. With merge set
+// the backup is merged into the current history (see Merge); otherwise the
+// current history is moved to the trash and replaced. It returns the number
+// of entries added.
+func (s *FileStore) RestoreBackup(b BackupInfo, merge bool) (int, error) {
+	backup, err := s.ReadBackup(b)
+	if err != nil {
+		return 0, err
+	}
+	var report MergeReport
+	err = s.Update(func(cd *ClipboardData) error {
+		if !merge {
+			cd.DiscardAll(time.Now())
+		}
+		report = cd.Merge(backup)
+		return nil
+	})
+	return report.Added, err
+}
+
+// MergeReport counts what Merge did with the entries and pins it was given.
+type MergeReport struct {
+	Added   int // entries that were missing
+	Updated int // entries already present that took newer metadata
+	Skipped int // entries already present with nothing newer
+	Pinned  int // entries that were pinned and now are
+}
+
+// Merge adds the entries of other that are missing from cd, each at the
+// position its last use puts it, and pins every entry pinned in other.
+// Entries present in both keep the earlier first use, the later last use
+// and the higher use count; entries without timestamps change nothing.
+func (cd *ClipboardData) Merge(other *ClipboardData) MergeReport {
+	var report MergeReport
+	for _, e := range other.History {
+		i := cd.IndexOf(e.ID)
+		if i < 0 {
+			cd.insert(e)
+			cd.dropFromTrash(e.ID)
+			report.Added++
+			continue
+		}
+		cur := cd.History[i]
+		merged := cur
+		if e.LastSeen.After(merged.LastSeen) {
+			merged.LastSeen = e.LastSeen
+		}
+		if !e.FirstSeen.IsZero() && (merged.FirstSeen.IsZero() || e.FirstSeen.Before(merged.FirstSeen)) {
+			merged.FirstSeen = e.FirstSeen
+		}
+		merged.UseCount = max(merged.UseCount, e.UseCount)
+		if merged == cur {
+			report.Skipped++
+			continue
+		}
+		cd.History = append(cd.History[:i], cd.History[i+1:]...)
+		cd.insert(merged)
+		report.Updated++
+	}
+	for id := range other.Pinned {
+		if !cd.Pinned[id] && cd.IndexOf(id) >= 0 {
+			cd.Pinned[id] = true
+			report.Pinned++
+		}
+	}
+	return report
+}
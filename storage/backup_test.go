@@ -0,0 +1,103 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// backupFile reads the only backup in dir.
+func backupFile(t *testing.T, s *FileStore) (BackupInfo, []byte) {
+	t.Helper()
+	backups, err := ListBackups(s.Dir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("got %d backups, want 1", len(backups))
+	}
+	data, err := os.ReadFile(filepath.Join(s.Dir(), BackupDirName, backups[0].Name+backupExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return backups[0], data
+}
+
+// restoreAndCheck clears the history of s, restores backup b and checks
+// that it brought back want.
+func restoreAndCheck(t *testing.T, s *FileStore, b BackupInfo, want ...string) {
+	t.Helper()
+	if err := s.Update(func(cd *ClipboardData) error {
+		cd.Clear()
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	added, err := s.RestoreBackup(b, false)
+	if err != nil {
+		t.Fatalf("restore %s: %v", b.Name, err)
+	}
+	if added != len(want) {
+		t.Errorf("restore %s added %d entries, want %d", b.Name, added, len(want))
+	}
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make(map[string]bool)
+	for _, e := range entries {
+		content, err := s.Content(e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got[content] = true
+	}
+	for _, w := range want {
+		if !got[w] {
+			t.Errorf("restore %s did not bring back %.20q", b.Name, w)
+		}
+	}
+}
+
+func TestRekeyBackups(t *testing.T) {
+	withBlobThreshold(t, 64)
+	s := NewFileStore(t.TempDir())
+	small, large := "small entry", strings.Repeat("large entry ", 20)
+	for _, content := range []string{small, large} {
+		if _, err := s.Add(Entry{Content: content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := s.Backup(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := s.Encrypt(KeySourceKeyfile, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Backups != 1 || len(report.Unreadable) > 0 {
+		t.Errorf("encrypt report %+v, want 1 backup", report)
+	}
+	b, data := backupFile(t, s)
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		t.Fatal("backup left in plaintext after encrypt")
+	}
+	restoreAndCheck(t, s, b, small, large)
+
+	if report, err = s.Decrypt(); err != nil {
+		t.Fatal(err)
+	}
+	if report.Backups != 1 {
+		t.Errorf("decrypt report %+v, want 1 backup", report)
+	}
+	b, data = backupFile(t, s)
+	if bytes.HasPrefix(data, encryptedMagic) {
+		t.Fatal("backup still sealed after decrypt")
+	}
+	// A fresh store holds no key at all
+	restoreAndCheck(t, NewFileStore(s.Dir()), b, small, large)
+}
@@ -0,0 +1,195 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BlobDirName is the data subdirectory holding large entry contents.
+const BlobDirName = "blobs"
+
+// BlobThreshold is the content size in bytes above which the file store
+// moves an entry's content into a compressed blob. Zero disables blobs.
+var BlobThreshold = 64 * 1024
+
+// blobPreviewLen is how much of a blob's content stays in the history index.
+const blobPreviewLen = 1024
+
+// blobName derives the file name of a blob from its content. Names of
+// encrypted blobs are keyed so they do not reveal the content hash.
+func blobName(content string, c *Cipher) string {
+	if c == nil {
+		sum := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// blobPath returns the path of the named blob in dir.
+func blobPath(dir, name string) string {
+	return filepath.Join(dir, BlobDirName, name)
+}
+
+// writeBlob stores content gzip-compressed (and sealed when c is set) under
+// its content-derived name. Existing blobs are left alone.
+func writeBlob(dir string, content string, c *Cipher) (string, error) {
+	name := blobName(content, c)
+	p := blobPath(dir, name)
+	if _, err := os.Stat(p); err == nil {
+		return name, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o700); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := io.WriteString(zw, content); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	data := buf.Bytes()
+	if c != nil {
+		data = sealFile(c, data)
+	}
+	if err := writeFileAtomic(p, data); err != nil {
+		return "", err
+	}
+	return name, nil
+}
+
+// readBlob returns the content stored in the named blob.
+func readBlob(dir, name string, c *Cipher) (string, error) {
+	data, err := os.ReadFile(blobPath(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("read blob %s: %w", name, err)
+	}
+	if data, err = openFile(c, data); err != nil {
+		return "", err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return "", fmt.Errorf("read blob %s: %w", name, err)
+	}
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("read blob %s: %w", name, err)
+	}
+	return string(content), nil
+}
+
+// spillBlobs moves the content of entries larger than BlobThreshold, and of
+// binary entries whatever their size, into blobs, leaving a preview (the
+// description of binary content) and the blob name in the index.
+func spillBlobs(dir string, clipData *ClipboardData, c *Cipher) error {
+	for i, e := range clipData.History {
+		if e.Blob != "" || !e.IsBinary() && (BlobThreshold <= 0 || len(e.Content) <= BlobThreshold) {
+			continue
+		}
+		name, err := writeBlob(dir, e.Content, c)
+		if err != nil {
+			return err
+		}
+		clipData.History[i].Blob = name
+		if e.IsBinary() {
+			clipData.History[i].Content = e.Describe()
+		} else {
+			clipData.History[i].Content = cutUTF8(e.Content, blobPreviewLen)
+		}
+	}
+	return nil
+}
+
+// referencedBlobs returns the set of blob names used by clipData, including
+// its trash.
+func referencedBlobs(clipData *ClipboardData) map[string]bool {
+	refs := make(map[string]bool)
+	for _, e := range clipData.History {
+		if e.Blob != "" {
+			refs[e.Blob] = true
+		}
+	}
+	for _, t := range clipData.Trash {
+		if t.Blob != "" {
+			refs[t.Blob] = true
+		}
+	}
+	return refs
+}
+
+// releaseBlobs deletes the blobs used by before that after no longer needs.
+func releaseBlobs(dir string, before, after *ClipboardData) error {
+	refs := referencedBlobs(after)
+	for name := range referencedBlobs(before) {
+		if refs[name] {
+			continue
+		}
+		if err := os.Remove(blobPath(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// sweepBlobs deletes every blob in dir that clipData does not reference,
+// including temporary files left behind by interrupted writes.
+func sweepBlobs(dir string, clipData *ClipboardData) error {
+	names, err := os.ReadDir(filepath.Join(dir, BlobDirName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	refs := referencedBlobs(clipData)
+	for _, de := range names {
+		name := de.Name()
+		if refs[name] && !strings.HasPrefix(name, ".") {
+			continue
+		}
+		if err := os.Remove(blobPath(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// rekeyBlobs rewrites every blob of clipData, including its trash, from
+// cipher from to cipher to, updating the entries with the new names. The
+// old blobs are left for sweepBlobs once the index no longer references
+// them.
+func rekeyBlobs(dir string, clipData *ClipboardData, from, to *Cipher) error {
+	entries := make([]*Entry, 0, len(clipData.History)+len(clipData.Trash))
+	for i := range clipData.History {
+		entries = append(entries, &clipData.History[i])
+	}
+	for i := range clipData.Trash {
+		entries = append(entries, &clipData.Trash[i].Entry)
+	}
+	for _, e := range entries {
+		if e.Blob == "" {
+			continue
+		}
+		content, err := readBlob(dir, e.Blob, from)
+		if err != nil {
+			return err
+		}
+		name, err := writeBlob(dir, content, to)
+		if err != nil {
+			return err
+		}
+		e.Blob = name
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testKey is a raw keyfile key, which avoids the passphrase KDF in tests.
+var testKey = bytes.Repeat([]byte{7}, KeySize)
+
+// withBlobThreshold sets BlobThreshold for the duration of a test.
+func withBlobThreshold(t *testing.T, n int) {
+	t.Helper()
+	old := BlobThreshold
+	BlobThreshold = n
+	t.Cleanup(func() { BlobThreshold = old })
+}
+
+// trashFirst moves the most recent entry of s into the trash.
+func trashFirst(t *testing.T, s Store) {
+	t.Helper()
+	if err := s.Update(func(cd *ClipboardData) error {
+		cd.Discard(0, time.Now())
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// restoreFirst restores the most recently trashed entry of s and returns
+// its full content.
+func restoreFirst(t *testing.T, s Store) string {
+	t.Helper()
+	var restored Entry
+	if err := s.Update(func(cd *ClipboardData) error {
+		if len(cd.Trash) == 0 {
+			t.Fatal("trash is empty")
+		}
+		restored = cd.Restore(0)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if restored.Blob == "" {
+		t.Fatal("restored entry has no blob")
+	}
+	content, err := s.Content(restored)
+	if err != nil {
+		t.Fatalf("read restored entry: %v", err)
+	}
+	return content
+}
+
+func TestRekeyTrashedBlobs(t *testing.T) {
+	withBlobThreshold(t, 64)
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	content := strings.Repeat("large clipboard entry ", 20)
+	if _, err := s.Add(Entry{Content: content}); err != nil {
+		t.Fatal(err)
+	}
+
+	trashFirst(t, s)
+	if _, err := s.Encrypt(KeySourceKeyfile, testKey); err != nil {
+		t.Fatal(err)
+	}
+	// No blob may keep its plaintext name or content
+	names, err := os.ReadDir(blobPath(dir, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, de := range names {
+		if de.Name() == blobName(content, nil) {
+			t.Errorf("plaintext blob %s left after encrypt", de.Name())
+		}
+	}
+	if got := restoreFirst(t, s); got != content {
+		t.Errorf("after encrypt: restored %q, want %q", got, content)
+	}
+
+	trashFirst(t, s)
+	if _, err := s.Decrypt(); err != nil {
+		t.Fatal(err)
+	}
+	if got := restoreFirst(t, s); got != content {
+		t.Errorf("after decrypt: restored %q, want %q", got, content)
+	}
+}
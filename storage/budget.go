@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"fmt"
+	"log"
+	"unicode/utf8"
+)
+
+// Policies for entries larger than MaxEntryBytes.
+const (
+	OversizeSkip     = "skip"     // drop the entry
+	OversizeTruncate = "truncate" // keep the first MaxEntryBytes with a marker
+)
+
+// MaxEntryBytes is the largest entry kept as is; 0 means unlimited
+// (configurable).
+var MaxEntryBytes = 0
+
+// OversizePolicy decides what happens to entries above MaxEntryBytes
+// (configurable).
+var OversizePolicy = OversizeSkip
+
+// MaxTotalBytes caps the combined size of all entries; 0 means unlimited
+// (configurable).
+var MaxTotalBytes int64 = 0
+
+// truncatedMarker is appended to content cut down by OversizeTruncate.
+const truncatedMarker = "\n…[truncated by clipcli, %s total]"
+
+// cutUTF8 returns at most n bytes of s without splitting a rune.
+func cutUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// limitEntrySizes applies MaxEntryBytes to unpinned entries that are still
+// stored inline.
+func limitEntrySizes(clipData *ClipboardData) {
+	if MaxEntryBytes <= 0 {
+		return
+	}
+	kept := clipData.History[:0]
+	seen := make(map[string]bool, len(clipData.History))
+	for _, e := range clipData.History {
+		if e.Blob == "" && len(e.Content) > MaxEntryBytes && !clipData.Pinned[e.ID] {
+			marker := fmt.Sprintf(truncatedMarker, FormatSize(len(e.Content)))
+			// Cutting binary content would only corrupt it
+			if OversizePolicy != OversizeTruncate || len(marker) >= MaxEntryBytes || e.IsBinary() {
+				log.Printf("skipped %s entry %s (max_entry_bytes %s)\n",
+					FormatSize(len(e.Content)), e.ID, FormatSize(MaxEntryBytes))
+				continue
+			}
+			// The marker counts towards the limit so the result is not cut again
+			content := cutUTF8(e.Content, MaxEntryBytes-len(marker)) + marker
+			log.Printf("truncated %s entry %s to %s (max_entry_bytes)\n",
+				FormatSize(len(e.Content)), e.ID, FormatSize(MaxEntryBytes))
+			e.ID = HashID(content)
+			e.Content = content
+			e.Size = len(content)
+		}
+		// Truncating can make an entry identical to one already kept
+		if seen[e.ID] {
+			continue
+		}
+		seen[e.ID] = true
+		kept = append(kept, e)
+	}
+	clipData.History = kept
+}
+
+// limitTotalSize evicts the oldest unpinned entries until the history fits
+// into MaxTotalBytes.
+func limitTotalSize(clipData *ClipboardData) {
+	if MaxTotalBytes <= 0 {
+		return
+	}
+	var total int64
+	for _, e := range clipData.History {
+		total += int64(e.Size)
+	}
+	evicted := 0
+	for i := len(clipData.History) - 1; i >= 0 && total > MaxTotalBytes; i-- {
+		e := clipData.History[i]
+		if clipData.Pinned[e.ID] {
+			continue
+		}
+		total -= int64(e.Size)
+		clipData.Remove(i)
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("evicted %d entries to stay within max_total_bytes %s\n", evicted, FormatSize(int(MaxTotalBytes)))
+	}
+}
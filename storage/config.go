@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"time"
+
+	"github/phaneendra24/goclipboard-manager/config"
+)
+
+// Configure applies the storage settings of a validated cfg: history limits,
+// retention (including the TTL of secrets), trash, blobs and backups.
+func Configure(cfg *config.Config) {
+	MaxHistory = cfg.MaxHistory
+	MaxEntryBytes = cfg.MaxEntryBytes
+	OversizePolicy = cfg.OversizePolicy
+	MaxTotalBytes = cfg.MaxTotalBytes
+	BlobThreshold = cfg.Storage.BlobThreshold
+	byType := make(map[string]time.Duration, len(cfg.Retention.Types))
+	for typ, d := range cfg.Retention.Types {
+		byType[typ] = time.Duration(d)
+	}
+	Retention = RetentionPolicy{
+		MaxAge:    time.Duration(cfg.Retention.MaxAge),
+		ByType:    byType,
+		Sensitive: time.Duration(cfg.Secrets.TTL),
+	}
+	TrashRetention = time.Duration(cfg.TrashRetention)
+	Backups = BackupPolicy{
+		Interval: time.Duration(cfg.Backup.Interval),
+		Daily:    cfg.Backup.Daily,
+		Weekly:   cfg.Backup.Weekly,
+	}
+}
@@ -0,0 +1,256 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeyParamsFileName is the name of the file describing how the history key
+// is obtained. Its presence marks the history as encrypted.
+const KeyParamsFileName = "clip_history.keyparams"
+
+// KeySize is the length of the AES-256 history key in bytes.
+const KeySize = 32
+
+// Key sources recorded in the key parameters.
+const (
+	KeySourcePassphrase = "passphrase"
+	KeySourceKeyfile    = "keyfile"
+)
+
+// pbkdf2Iterations is the PBKDF2-HMAC-SHA256 work factor for passphrases.
+const pbkdf2Iterations = 600000
+
+// encryptedMagic prefixes an encrypted history file.
+var encryptedMagic = []byte("CLIPENC1\n")
+
+// keyCheck is sealed with the key so a wrong key is detected up front.
+var keyCheck = []byte("clipcli key check")
+
+var (
+	// ErrLocked is returned when the history is encrypted and no key is loaded.
+	ErrLocked = errors.New("history is encrypted and locked (run clipcli unlock)")
+	// ErrWrongKey is returned when a key does not match the history.
+	ErrWrongKey = errors.New("wrong passphrase or key")
+	// ErrNotEncrypted is returned when encryption settings are requested for a
+	// plaintext history.
+	ErrNotEncrypted = errors.New("history is not encrypted")
+)
+
+// KeyParams describes how the history key is obtained and lets a candidate
+// key be verified without touching the history itself.
+type KeyParams struct {
+	Source     string `json:"source"`
+	KDF        string `json:"kdf,omitempty"`
+	Iterations int    `json:"iterations,omitempty"`
+	Salt       []byte `json:"salt,omitempty"`
+	Check      []byte `json:"check"`
+}
+
+// Cipher seals and opens history data with AES-256-GCM.
+type Cipher struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+// NewCipher returns a cipher for a KeySize-byte key.
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("history key must be %d bytes, got %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{key: append([]byte(nil), key...), aead: aead}, nil
+}
+
+// Key returns the raw key.
+func (c *Cipher) Key() []byte {
+	return append([]byte(nil), c.key...)
+}
+
+// Seal encrypts plain, prefixing the random nonce.
+func (c *Cipher) Seal(plain []byte) []byte {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand never fails on supported platforms
+	}
+	return c.aead.Seal(nonce, nonce, plain, nil)
+}
+
+// Open decrypts data produced by Seal.
+func (c *Cipher) Open(data []byte) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(data) < n {
+		return nil, ErrWrongKey
+	}
+	plain, err := c.aead.Open(nil, data[:n], data[n:], nil)
+	if err != nil {
+		return nil, ErrWrongKey
+	}
+	return plain, nil
+}
+
+// NewKeyParams creates parameters for a fresh passphrase- or keyfile-based
+// key and the matching cipher.
+func NewKeyParams(source string, secret []byte) (*KeyParams, *Cipher, error) {
+	p := &KeyParams{Source: source}
+	if source == KeySourcePassphrase {
+		p.KDF = "pbkdf2-sha256"
+		p.Iterations = pbkdf2Iterations
+		p.Salt = make([]byte, 16)
+		if _, err := rand.Read(p.Salt); err != nil {
+			return nil, nil, err
+		}
+	}
+	key, err := p.key(secret)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	p.Check = c.Seal(keyCheck)
+	return p, c, nil
+}
+
+// Derive turns a passphrase or keyfile contents into a verified cipher.
+func (p *KeyParams) Derive(secret []byte) (*Cipher, error) {
+	key, err := p.key(secret)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.Verify(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Verify checks that c holds the key these parameters were created with.
+func (p *KeyParams) Verify(c *Cipher) error {
+	plain, err := c.Open(p.Check)
+	if err != nil || !bytes.Equal(plain, keyCheck) {
+		return ErrWrongKey
+	}
+	return nil
+}
+
+// key derives the raw key from a secret according to the parameters.
+func (p *KeyParams) key(secret []byte) ([]byte, error) {
+	switch p.Source {
+	case KeySourcePassphrase:
+		if len(secret) == 0 {
+			return nil, errors.New("empty passphrase")
+		}
+		return pbkdf2Key(secret, p.Salt, p.Iterations)
+	case KeySourceKeyfile:
+		return ParseKeyfile(secret)
+	default:
+		return nil, fmt.Errorf("unknown key source %q", p.Source)
+	}
+}
+
+// ParseKeyfile decodes keyfile contents: a raw KeySize-byte key or its hex or
+// base64 encoding.
+func ParseKeyfile(data []byte) ([]byte, error) {
+	if len(data) == KeySize {
+		return data, nil
+	}
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && len(key) == KeySize {
+		return key, nil
+	}
+	return nil, fmt.Errorf("keyfile must hold a %d-byte key (raw, hex or base64)", KeySize)
+}
+
+// GenerateKeyfile writes a new random key, hex encoded, to path.
+func GenerateKeyfile(path string) error {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, hex.EncodeToString(key)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadKeyParams loads the key parameters from dir, returning ErrNotEncrypted
+// if the history there is plaintext.
+func ReadKeyParams(dir string) (*KeyParams, error) {
+	data, err := os.ReadFile(filepath.Join(dir, KeyParamsFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotEncrypted
+		}
+		return nil, err
+	}
+	var p KeyParams
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, fmt.Errorf("key parameters: %w", err)
+	}
+	return &p, nil
+}
+
+// writeKeyParams stores the key parameters in dir.
+func writeKeyParams(dir string, p *KeyParams) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, KeyParamsFileName), data)
+}
+
+// pbkdf2Key derives a key from a passphrase with PBKDF2-HMAC-SHA256.
+func pbkdf2Key(passphrase, salt []byte, iterations int) ([]byte, error) {
+	return pbkdf2.Key(sha256.New, string(passphrase), salt, iterations, KeySize)
+}
+
+// sealFile encrypts a whole history file.
+func sealFile(c *Cipher, plain []byte) []byte {
+	return append(append([]byte(nil), encryptedMagic...), c.Seal(plain)...)
+}
+
+// openFile decrypts a history file if it is encrypted.
+func openFile(c *Cipher, data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, encryptedMagic) {
+		return data, nil
+	}
+	if c == nil {
+		return nil, ErrLocked
+	}
+	return c.Open(data[len(encryptedMagic):])
+}
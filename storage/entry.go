@@ -0,0 +1,279 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Content types recorded on history entries.
+const (
+	TypeText  = "text"
+	TypeURL   = "url"
+	TypePath  = "path"
+	TypeImage = "image"
+)
+
+// Sources recorded on history entries.
+const (
+	SourceDaemon = "daemon"
+	SourceSave   = "save"
+	SourceStore  = "store" // clipcli store, e.g. run by wl-paste --watch
+)
+
+// Selections content is captured from. Entries record only PRIMARY; an
+// empty Selection means CLIPBOARD.
+const (
+	SelectionClipboard = "clipboard"
+	SelectionPrimary   = "primary"
+)
+
+// IDLength is the number of hex digits in an entry ID.
+const IDLength = 12
+
+// MinRefPrefix is the shortest ID prefix accepted by Resolve. Shorter
+// all-digit references are treated as positional indices.
+const MinRefPrefix = 6
+
+// ErrNotFound is returned when a reference matches no history entry.
+var ErrNotFound = errors.New("no such entry")
+
+// Entry is a single clipboard history item together with its metadata. When
+// Blob is set the full content lives in that blob and Content only holds a
+// preview; use Store.Content to get all of it. Binary content, such as an
+// image, has its MIME type set and is always kept in a blob by the file
+// store. Entries flagged as secrets have Secret set and are masked in lists.
+type Entry struct {
+	ID        string    `json:"id"`
+	Content   string    `json:"content"`
+	Blob      string    `json:"blob,omitempty"`
+	Type      string    `json:"type"`
+	MIME      string    `json:"mime,omitempty"`
+	Width     int       `json:"width,omitempty"` // of images, in pixels
+	Height    int       `json:"height,omitempty"`
+	Size      int       `json:"size"`
+	Source    string    `json:"source,omitempty"`
+	Selection string    `json:"selection,omitempty"`
+	Secret    string    `json:"secret,omitempty"` // kind of secret, for sensitive entries
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	UseCount  int       `json:"use_count"`
+}
+
+// HashID derives the stable ID of a piece of content: the leading hex digits
+// of its SHA-256 digest.
+func HashID(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:IDLength]
+}
+
+// NewEntry builds an entry for freshly captured content.
+func NewEntry(content, source string, now time.Time) Entry {
+	return Entry{
+		ID:        HashID(content),
+		Content:   content,
+		Type:      DetectType(content),
+		Size:      len(content),
+		Source:    source,
+		FirstSeen: now,
+		LastSeen:  now,
+	}
+}
+
+// SelectionName returns the selection the entry was last captured from.
+func (e Entry) SelectionName() string {
+	if e.Selection == "" {
+		return SelectionClipboard
+	}
+	return e.Selection
+}
+
+// DetectType guesses the content type of a clipboard payload.
+func DetectType(content string) string {
+	s := strings.TrimSpace(content)
+	if s == "" || strings.ContainsAny(s, "\n\t ") {
+		return TypeText
+	}
+	if u, err := url.Parse(s); err == nil && u.Scheme != "" && u.Host != "" {
+		return TypeURL
+	}
+	if strings.HasPrefix(s, "/") || strings.HasPrefix(s, "~/") || strings.HasPrefix(s, "file://") {
+		return TypePath
+	}
+	return TypeText
+}
+
+// Find returns the index of the entry holding content, or -1.
+func (cd *ClipboardData) Find(content string) int {
+	id := HashID(content)
+	for i, e := range cd.History {
+		// Blob entries only keep a preview; their ID is the content hash
+		if e.ID == id && (e.Blob != "" || e.Content == content) {
+			return i
+		}
+	}
+	return -1
+}
+
+// IndexOf returns the index of the entry with the given ID, or -1.
+func (cd *ClipboardData) IndexOf(id string) int {
+	for i, e := range cd.History {
+		if e.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// Capture records content as the most recent history entry. If the content
+// is already present it is moved to the top and its metadata is updated
+// instead of being duplicated.
+func (cd *ClipboardData) Capture(content, source string, now time.Time) Entry {
+	return cd.Put(Entry{Content: content, Source: source}, now)
+}
+
+// Put adds e as the most recent history entry, filling in its ID, size,
+// timestamps and (if unset) type, and for binary content of type e.MIME
+// the image size. An existing entry with the same content is
+// moved to the top instead of being duplicated, taking e's selection and,
+// if set, its secret flag.
+func (cd *ClipboardData) Put(e Entry, now time.Time) Entry {
+	if i := cd.Find(e.Content); i >= 0 {
+		existing := cd.History[i]
+		existing.LastSeen = now
+		existing.Selection = e.Selection
+		if e.Secret != "" {
+			existing.Secret = e.Secret
+		}
+		cd.History = append(cd.History[:i], cd.History[i+1:]...)
+		cd.History = append([]Entry{existing}, cd.History...)
+		return existing
+	}
+	fresh := NewEntry(e.Content, e.Source, now)
+	if e.Type != "" {
+		fresh.Type = e.Type
+	}
+	fresh.Selection = e.Selection
+	fresh.Secret = e.Secret
+	if e.MIME != "" {
+		fresh.MIME = e.MIME
+		fresh.Width, fresh.Height = imageSize(e.Content)
+		if strings.HasPrefix(e.MIME, "image/") {
+			fresh.Type = TypeImage
+		}
+	}
+	cd.History = append([]Entry{fresh}, cd.History...)
+	return fresh
+}
+
+// insert adds e at the position its LastSeen time puts it in the history,
+// which is ordered most recent first: in front of the first entry last seen
+// before it. The history is scanned rather than searched since histories
+// trimmed by earlier versions may be out of order.
+func (cd *ClipboardData) insert(e Entry) {
+	pos := slices.IndexFunc(cd.History, func(h Entry) bool {
+		return h.LastSeen.Before(e.LastSeen)
+	})
+	if pos < 0 {
+		pos = len(cd.History)
+	}
+	cd.History = slices.Insert(cd.History, pos, e)
+}
+
+// Resolve finds the entry a user-supplied reference points at. Short
+// all-digit references are positional indices (0 = most recent); anything
+// else is matched as a prefix of an entry ID.
+func (cd *ClipboardData) Resolve(ref string) (int, error) {
+	return resolveRef(ref, len(cd.History), func(i int) string { return cd.History[i].ID })
+}
+
+// resolveRef resolves ref against n entries whose IDs are returned by id.
+func resolveRef(ref string, n int, id func(int) string) (int, error) {
+	ref = strings.ToLower(strings.TrimSpace(ref))
+	if len(ref) < MinRefPrefix {
+		idx, err := strconv.Atoi(ref)
+		if err != nil {
+			return -1, fmt.Errorf("invalid reference %q: use an index or at least %d ID digits", ref, MinRefPrefix)
+		}
+		if idx < 0 || idx >= n {
+			if n == 0 {
+				return -1, errors.New("history empty")
+			}
+			return -1, fmt.Errorf("index out of range (0..%d)", n-1)
+		}
+		return idx, nil
+	}
+	found := -1
+	for i := 0; i < n; i++ {
+		if strings.HasPrefix(id(i), ref) {
+			if found >= 0 {
+				return -1, fmt.Errorf("ambiguous ID prefix %q", ref)
+			}
+			found = i
+		}
+	}
+	if found < 0 {
+		return -1, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	}
+	return found, nil
+}
+
+// IsPinned reports whether the entry with the given ID is pinned.
+func (cd *ClipboardData) IsPinned(id string) bool {
+	return cd.Pinned[id]
+}
+
+// Remove deletes the entry at index i along with its pin for good; see
+// Discard for a delete that can be undone.
+func (cd *ClipboardData) Remove(i int) {
+	delete(cd.Pinned, cd.History[i].ID)
+	cd.History = append(cd.History[:i], cd.History[i+1:]...)
+}
+
+// Clear removes every entry and pin for good; see DiscardAll for a clear
+// that can be undone.
+func (cd *ClipboardData) Clear() {
+	cd.History = []Entry{}
+	cd.Pinned = make(map[string]bool)
+}
+
+// MarkUsed bumps the use counter of the entry at index i.
+func (cd *ClipboardData) MarkUsed(i int) {
+	cd.History[i].UseCount++
+}
+
+// FormatSize renders a byte count in a short human-readable form.
+func FormatSize(n int) string {
+	switch {
+	case n < 1024:
+		return fmt.Sprintf("%dB", n)
+	case n < 1024*1024:
+		return fmt.Sprintf("%.1fK", float64(n)/1024)
+	default:
+		return fmt.Sprintf("%.1fM", float64(n)/(1024*1024))
+	}
+}
+
+// FormatAge renders the time elapsed since t, e.g. "5m" or "3d".
+func FormatAge(t time.Time, now time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d/time.Minute))
+	case d < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(d/time.Hour))
+	default:
+		return fmt.Sprintf("%dd", int(d/(24*time.Hour)))
+	}
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats.
+const (
+	FormatJSON     = "json"   // indented array of records
+	FormatNDJSON   = "ndjson" // one record per line
+	FormatCSV      = "csv"    // header row, then one record per row
+	FormatMarkdown = "md"     // a section per entry with its content fenced
+	FormatRaw0     = "raw0"   // bare contents, each followed by a NUL byte
+)
+
+// ExportFormats lists the formats Export writes.
+var ExportFormats = []string{FormatJSON, FormatNDJSON, FormatCSV, FormatMarkdown, FormatRaw0}
+
+// ExportRecord is an entry as exported: full content, pin status and
+// metadata, with times in UTC.
+type ExportRecord struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	Pinned    bool      `json:"pinned"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	UseCount  int       `json:"use_count"`
+	Size      int       `json:"size"`
+	Source    string    `json:"source,omitempty"`
+	Selection string    `json:"selection,omitempty"`
+	Content   string    `json:"content"`
+}
+
+// csvHeader names the CSV columns in the order they are written.
+var csvHeader = []string{"id", "type", "pinned", "first_seen", "last_seen", "use_count", "size", "source", "selection", "content"}
+
+// NewExportRecord builds the record of e with its full content.
+func NewExportRecord(e Entry, content string, pinned bool) ExportRecord {
+	return ExportRecord{
+		ID:        e.ID,
+		Type:      e.Type,
+		Pinned:    pinned,
+		FirstSeen: e.FirstSeen.UTC(),
+		LastSeen:  e.LastSeen.UTC(),
+		UseCount:  e.UseCount,
+		Size:      len(content),
+		Source:    e.Source,
+		Selection: e.Selection,
+		Content:   content,
+	}
+}
+
+// SortExport orders records oldest first, so new entries are appended at
+// the end and exports of a growing history diff cleanly.
+func SortExport(recs []ExportRecord) {
+	sort.SliceStable(recs, func(i, j int) bool {
+		if !recs[i].FirstSeen.Equal(recs[j].FirstSeen) {
+			return recs[i].FirstSeen.Before(recs[j].FirstSeen)
+		}
+		return recs[i].ID < recs[j].ID
+	})
+}
+
+// Export writes recs to w in the given format.
+func Export(w io.Writer, format string, recs []ExportRecord) error {
+	bw := bufio.NewWriter(w)
+	var err error
+	switch format {
+	case FormatJSON:
+		if recs == nil {
+			recs = []ExportRecord{}
+		}
+		var data []byte
+		if data, err = json.MarshalIndent(recs, "", "  "); err == nil {
+			data = append(data, '\n')
+			_, err = bw.Write(data)
+		}
+	case FormatNDJSON:
+		enc := json.NewEncoder(bw)
+		for _, r := range recs {
+			if err = enc.Encode(r); err != nil {
+				break
+			}
+		}
+	case FormatCSV:
+		err = exportCSV(bw, recs)
+	case FormatMarkdown:
+		err = exportMarkdown(bw, recs)
+	case FormatRaw0:
+		for _, r := range recs {
+			if _, err = bw.WriteString(r.Content + "\x00"); err != nil {
+				break
+			}
+		}
+	default:
+		return fmt.Errorf("unknown export format %q (want one of %s)", format, strings.Join(ExportFormats, ", "))
+	}
+	if err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// exportCSV writes recs as CSV with a header row.
+func exportCSV(w io.Writer, recs []ExportRecord) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, r := range recs {
+		row := []string{
+			r.ID, r.Type, strconv.FormatBool(r.Pinned),
+			r.FirstSeen.Format(time.RFC3339Nano), r.LastSeen.Format(time.RFC3339Nano),
+			strconv.Itoa(r.UseCount), strconv.Itoa(r.Size), r.Source, r.Selection, r.Content,
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// exportMarkdown writes a heading and metadata list per record, followed by
+// its content in a code fence longer than any backtick run inside it.
+func exportMarkdown(w io.Writer, recs []ExportRecord) error {
+	if _, err := fmt.Fprintf(w, "# Clipboard history\n"); err != nil {
+		return err
+	}
+	for _, r := range recs {
+		title := r.ID + " (" + r.Type
+		if r.Pinned {
+			title += ", pinned"
+		}
+		title += ")"
+		selection := ""
+		if r.Selection != "" {
+			selection = "- selection: " + r.Selection + "\n"
+		}
+		fence := strings.Repeat("`", max(3, longestRun(r.Content, '`')+1))
+		content := r.Content
+		if !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		_, err := fmt.Fprintf(w, "\n## %s\n\n- first seen: %s\n- last seen: %s\n- uses: %d\n- size: %s\n%s\n%s\n%s%s\n",
+			title, r.FirstSeen.Format(time.RFC3339Nano), r.LastSeen.Format(time.RFC3339Nano), r.UseCount,
+			FormatSize(r.Size), selection, fence, content, fence)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// longestRun returns the length of the longest run of c in s.
+func longestRun(s string, c byte) int {
+	longest, run := 0, 0
+	for i := 0; i < len(s); i++ {
+		if s[i] != c {
+			run = 0
+			continue
+		}
+		run++
+		longest = max(longest, run)
+	}
+	return longest
+}
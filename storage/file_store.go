@@ -0,0 +1,385 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// FileStore keeps history in a JSON file plus an append-only journal inside
+// a data directory. Every transaction holds an flock on the directory's lock
+// file, so several processes can share one FileStore safely. An encrypted
+// history can only be used once the store holds its key.
+type FileStore struct {
+	ops
+	dir        string
+	cipher     atomic.Pointer[Cipher]
+	compacting atomic.Bool // set while a background compaction is running
+}
+
+var _ Store = (*FileStore)(nil)
+
+// NewFileStore returns a store backed by the files in dir.
+func NewFileStore(dir string) *FileStore {
+	s := &FileStore{dir: dir}
+	s.ops = ops{tx: s}
+	return s
+}
+
+// Dir returns the data directory of the store.
+func (s *FileStore) Dir() string {
+	return s.dir
+}
+
+// Update runs fn as a transaction against the on-disk history. The history
+// is loaded and the resulting changes are journaled while an exclusive lock
+// is held, so concurrent writers (daemon, GUI, CLI) never overwrite each
+// other's changes. If fn returns an error nothing is written; ErrNoChange is
+// not reported.
+func (s *FileStore) Update(fn func(*ClipboardData) error) error {
+	lock, err := acquireLock(s.dir, true, LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	c, err := s.activeCipher()
+	if err != nil {
+		return err
+	}
+	clipData, st, err := loadState(s.dir, c)
+	if err != nil {
+		return err
+	}
+	before := clipData.clone()
+	if err := fn(clipData); err != nil {
+		if errors.Is(err, ErrNoChange) {
+			return nil
+		}
+		return err
+	}
+	trimHistory(clipData)
+	if err := spillBlobs(s.dir, clipData, c); err != nil {
+		return err
+	}
+	if err := appendJournal(st, before, clipData); err != nil {
+		return err
+	}
+	// Only drop blobs once the journal no longer needs them
+	if err := releaseBlobs(s.dir, before, clipData); err != nil {
+		log.Printf("blob cleanup failed: %v\n", err)
+	}
+	if st.needsCompaction(clipData) {
+		s.compactInBackground()
+	}
+	return nil
+}
+
+// View runs fn against a consistent snapshot of the history while holding a
+// shared lock. Changes made by fn are not persisted.
+func (s *FileStore) View(fn func(*ClipboardData) error) error {
+	lock, err := acquireLock(s.dir, false, LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	c, err := s.activeCipher()
+	if err != nil {
+		return err
+	}
+	clipData, _, err := loadState(s.dir, c)
+	if err != nil {
+		return err
+	}
+	return fn(clipData)
+}
+
+// Content returns the full content of e, reading it from its blob if needed.
+func (s *FileStore) Content(e Entry) (string, error) {
+	if e.Blob == "" {
+		return e.Content, nil
+	}
+	lock, err := acquireLock(s.dir, false, LockTimeout)
+	if err != nil {
+		return "", err
+	}
+	defer lock.release()
+
+	c, err := s.activeCipher()
+	if err != nil {
+		return "", err
+	}
+	return readBlob(s.dir, e.Blob, c)
+}
+
+// compactInBackground starts a compaction unless one is already running.
+func (s *FileStore) compactInBackground() {
+	if !s.compacting.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.compacting.Store(false)
+		if err := s.Compact(); err != nil {
+			log.Printf("journal compaction failed: %v\n", err)
+		}
+	}()
+}
+
+// Compact folds the journal into the history file and removes blobs no
+// entry refers to. The new snapshot carries the next generation before the
+// journal is replaced, so a crash between the two steps leaves a stale
+// journal that is ignored rather than replayed twice.
+func (s *FileStore) Compact() error {
+	lock, err := acquireLock(s.dir, true, LockTimeout)
+	if err != nil {
+		return err
+	}
+	defer lock.release()
+
+	c, err := s.activeCipher()
+	if err != nil {
+		return err
+	}
+	clipData, st, err := loadState(s.dir, c)
+	if err != nil {
+		return err
+	}
+	if st.fileLen > 0 || st.version < SchemaVersion {
+		if err := compactJournal(st, clipData); err != nil {
+			return err
+		}
+	}
+	return sweepBlobs(s.dir, clipData)
+}
+
+// activeCipher returns the cipher to use for the history: nil while it is
+// plaintext, the verified key once encrypted, or ErrLocked without a key.
+func (s *FileStore) activeCipher() (*Cipher, error) {
+	params, err := ReadKeyParams(s.dir)
+	if errors.Is(err, ErrNotEncrypted) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := s.cipher.Load()
+	if c == nil {
+		return nil, ErrLocked
+	}
+	if err := params.Verify(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Encrypted reports whether the history in this store is encrypted.
+func (s *FileStore) Encrypted() (bool, error) {
+	_, err := ReadKeyParams(s.dir)
+	if errors.Is(err, ErrNotEncrypted) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Unlocked reports whether the store holds a key.
+func (s *FileStore) Unlocked() bool {
+	return s.cipher.Load() != nil
+}
+
+// KeyParams returns the key parameters of an encrypted history.
+func (s *FileStore) KeyParams() (*KeyParams, error) {
+	return ReadKeyParams(s.dir)
+}
+
+// Unlock derives the history key from a passphrase or keyfile contents and
+// keeps it in memory.
+func (s *FileStore) Unlock(secret []byte) error {
+	params, err := ReadKeyParams(s.dir)
+	if err != nil {
+		return err
+	}
+	c, err := params.Derive(secret)
+	if err != nil {
+		return err
+	}
+	s.cipher.Store(c)
+	return nil
+}
+
+// SetKey installs an already derived key, e.g. one handed out by the agent.
+func (s *FileStore) SetKey(key []byte) error {
+	params, err := ReadKeyParams(s.dir)
+	if err != nil {
+		return err
+	}
+	c, err := NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if err := params.Verify(c); err != nil {
+		return err
+	}
+	s.cipher.Store(c)
+	return nil
+}
+
+// Key returns the history key held by the store, or nil when locked.
+func (s *FileStore) Key() []byte {
+	if c := s.cipher.Load(); c != nil {
+		return c.Key()
+	}
+	return nil
+}
+
+// Lock forgets the history key.
+func (s *FileStore) Lock() {
+	s.cipher.Store(nil)
+}
+
+// RekeyReport tells what Encrypt and Decrypt converted along with the
+// history.
+type RekeyReport struct {
+	Backups    int      // backups in the backups directory
+	Copies     []string // migration backups and fsck quarantines
+	Unreadable []string // copies the old key could not open, left as they were
+}
+
+// historyCopies returns the raw copies of the history file kept next to it:
+// backups taken before schema migrations and corrupt files moved aside by
+// Fsck.
+func historyCopies(dir string) ([]string, error) {
+	var paths []string
+	for _, pattern := range []string{HistoryFileName + ".v*.bak", HistoryFileName + ".corrupt-*"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, matches...)
+	}
+	return paths, nil
+}
+
+// rekeyCopies rewrites the history copies in dir from cipher from to
+// cipher to and adds them to report.
+func rekeyCopies(dir string, from, to *Cipher, report *RekeyReport) error {
+	paths, err := historyCopies(dir)
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		raw, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		// A quarantined file may be damaged ciphertext that never opens
+		data, err := openFile(from, raw)
+		if err != nil {
+			report.Unreadable = append(report.Unreadable, filepath.Base(p))
+			continue
+		}
+		if to != nil {
+			data = sealFile(to, data)
+		}
+		if err := writeFileAtomic(p, data); err != nil {
+			return err
+		}
+		report.Copies = append(report.Copies, filepath.Base(p))
+	}
+	return nil
+}
+
+// Encrypt converts a plaintext history, its blobs, its backups and the
+// copies kept by migrations and Fsck to encrypted ones using a passphrase
+// or keyfile contents, and unlocks the store with the new key.
+func (s *FileStore) Encrypt(source string, secret []byte) (*RekeyReport, error) {
+	lock, err := acquireLock(s.dir, true, LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	if _, err := ReadKeyParams(s.dir); err == nil {
+		return nil, errors.New("history is already encrypted")
+	} else if !errors.Is(err, ErrNotEncrypted) {
+		return nil, err
+	}
+	clipData, st, err := loadState(s.dir, nil)
+	if err != nil {
+		return nil, err
+	}
+	params, c, err := NewKeyParams(source, secret)
+	if err != nil {
+		return nil, err
+	}
+	// Parameters go first: a crash afterwards leaves a readable plaintext
+	// history, never ciphertext without its salt.
+	if err := writeKeyParams(s.dir, params); err != nil {
+		return nil, err
+	}
+	if err := rekeyBlobs(s.dir, clipData, nil, c); err != nil {
+		return nil, fmt.Errorf("encrypt history: %w", err)
+	}
+	report := &RekeyReport{}
+	if report.Backups, report.Unreadable, err = rekeyBackups(s.dir, nil, c); err != nil {
+		return report, fmt.Errorf("encrypt backups: %w", err)
+	}
+	if err := rekeyCopies(s.dir, nil, c, report); err != nil {
+		return report, fmt.Errorf("encrypt history copies: %w", err)
+	}
+	st.cipher = c
+	if err := compactJournal(st, clipData); err != nil {
+		return report, fmt.Errorf("encrypt history: %w", err)
+	}
+	s.cipher.Store(c)
+	// The plaintext index would reveal the content; rebuild it sealed
+	removeIndex(s.dir)
+	return report, sweepBlobs(s.dir, clipData)
+}
+
+// Decrypt converts an encrypted history, its blobs, its backups and the
+// copies kept by migrations and Fsck back to plaintext. The store must be
+// unlocked.
+func (s *FileStore) Decrypt() (*RekeyReport, error) {
+	lock, err := acquireLock(s.dir, true, LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	c, err := s.activeCipher()
+	if err != nil {
+		return nil, err
+	}
+	if c == nil {
+		return nil, ErrNotEncrypted
+	}
+	clipData, st, err := loadState(s.dir, c)
+	if err != nil {
+		return nil, err
+	}
+	if err := rekeyBlobs(s.dir, clipData, c, nil); err != nil {
+		return nil, fmt.Errorf("decrypt history: %w", err)
+	}
+	// Backups and copies must be opened before the key parameters go
+	report := &RekeyReport{}
+	if report.Backups, report.Unreadable, err = rekeyBackups(s.dir, c, nil); err != nil {
+		return report, fmt.Errorf("decrypt backups: %w", err)
+	}
+	if err := rekeyCopies(s.dir, c, nil, report); err != nil {
+		return report, fmt.Errorf("decrypt history copies: %w", err)
+	}
+	st.cipher = nil
+	if err := compactJournal(st, clipData); err != nil {
+		return report, fmt.Errorf("decrypt history: %w", err)
+	}
+	if err := os.Remove(filepath.Join(s.dir, KeyParamsFileName)); err != nil {
+		return report, err
+	}
+	s.cipher.Store(nil)
+	removeIndex(s.dir)
+	return report, sweepBlobs(s.dir, clipData)
+}
@@ -0,0 +1,262 @@
+package storage
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"path/filepath"
+	"strings"
+	"unicode/utf16"
+)
+
+// Clipboard managers whose histories ParseForeign reads.
+const (
+	ManagerClipman = "clipman"
+	ManagerGPaste  = "gpaste"
+	ManagerKlipper = "klipper"
+)
+
+// ForeignManagers lists the managers ParseForeign reads.
+var ForeignManagers = []string{ManagerClipman, ManagerGPaste, ManagerKlipper}
+
+// ForeignHistoryPath returns where manager keeps its history by default.
+func ForeignHistoryPath(manager string) (string, error) {
+	var rel string
+	switch manager {
+	case ManagerClipman:
+		rel = "clipman.json"
+	case ManagerGPaste:
+		rel = filepath.Join("gpaste", "history.xml")
+	case ManagerKlipper:
+		rel = filepath.Join("klipper", "history2.lst")
+	default:
+		return "", unknownManager(manager)
+	}
+	dir, err := dataHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+func unknownManager(manager string) error {
+	return fmt.Errorf("unknown clipboard manager %q (want one of %s)", manager, strings.Join(ForeignManagers, ", "))
+}
+
+// ParseForeign reads the history file of another clipboard manager. The
+// entries keep the manager's order and are tagged with its name as their
+// source; items that are not text, such as images, are skipped.
+func ParseForeign(data []byte, manager string) (*Imported, error) {
+	var contents []string // most recent first
+	skipped := 0
+	var err error
+	switch manager {
+	case ManagerClipman:
+		contents, err = parseClipman(data)
+	case ManagerGPaste:
+		contents, skipped, err = parseGPaste(data)
+	case ManagerKlipper:
+		contents, skipped, err = parseKlipper(data)
+	default:
+		return nil, unknownManager(manager)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s history: %w", manager, err)
+	}
+	imp := &Imported{Data: newClipboardData(), Skipped: skipped}
+	seen := make(map[string]bool)
+	for _, content := range contents {
+		id := HashID(content)
+		if content == "" || seen[id] {
+			imp.Skipped++
+			continue
+		}
+		seen[id] = true
+		imp.Data.History = append(imp.Data.History, Entry{
+			ID:      id,
+			Content: content,
+			Type:    DetectType(content),
+			Size:    len(content),
+			Source:  manager,
+		})
+	}
+	return imp, nil
+}
+
+// parseClipman reads clipman's history: a JSON array of strings, oldest
+// first.
+func parseClipman(data []byte) ([]string, error) {
+	var items []string
+	if err := json.Unmarshal(data, &items); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(items)-1; i < j; i, j = i+1, j-1 {
+		items[i], items[j] = items[j], items[i]
+	}
+	return items, nil
+}
+
+// gpasteItem is an item of GPaste's history.xml. Version 2 files hold the
+// text in a value element, older ones directly in the item.
+type gpasteItem struct {
+	Kind  string  `xml:"kind,attr"`
+	Value *string `xml:"value"`
+	Text  string  `xml:",chardata"`
+}
+
+// parseGPaste reads GPaste's history.xml, most recent first. Text and file
+// list items are imported; images and passwords are not.
+func parseGPaste(data []byte) ([]string, int, error) {
+	var doc struct {
+		Items []gpasteItem `xml:"item"`
+	}
+	if err := xml.Unmarshal(data, &doc); err != nil {
+		return nil, 0, err
+	}
+	var contents []string
+	skipped := 0
+	for _, item := range doc.Items {
+		if item.Kind != "Text" && item.Kind != "Uris" {
+			skipped++
+			continue
+		}
+		if item.Value != nil {
+			contents = append(contents, *item.Value)
+		} else {
+			contents = append(contents, item.Text)
+		}
+	}
+	return contents, skipped, nil
+}
+
+// errTruncated reports a Klipper history that ends inside an item.
+var errTruncated = errors.New("truncated file")
+
+// qstream reads the big-endian encoding of Qt's QDataStream. The first error
+// sticks and makes every later read return zero values.
+type qstream struct {
+	data []byte
+	err  error
+}
+
+func (q *qstream) next(n int) []byte {
+	if q.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(q.data) {
+		q.err = errTruncated
+		return nil
+	}
+	b := q.data[:n]
+	q.data = q.data[n:]
+	return b
+}
+
+func (q *qstream) uint32() uint32 {
+	if b := q.next(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+// count reads the length of a list whose items take at least size bytes
+// each, rejecting lengths the remaining data cannot hold.
+func (q *qstream) count(size int) int {
+	n := q.uint32()
+	if q.err == nil && int64(n) > int64(len(q.data)/size) {
+		q.err = errTruncated
+	}
+	if q.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+// bytes reads a QByteArray or char*: a length, 0xffffffff for null, then
+// the bytes.
+func (q *qstream) bytes() []byte {
+	n := q.uint32()
+	if n == 0xffffffff {
+		return nil
+	}
+	return q.next(int(n))
+}
+
+// string reads a QString: a length in bytes, then UTF-16 code units.
+func (q *qstream) string() string {
+	b := q.bytes()
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = binary.BigEndian.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units))
+}
+
+// skipPNG skips a PNG image, which carries no length of its own, by walking
+// its chunks up to IEND.
+func (q *qstream) skipPNG() {
+	if sig := q.next(8); sig != nil && string(sig) != "\x89PNG\r\n\x1a\n" {
+		q.err = errors.New("image is not a PNG")
+	}
+	for q.err == nil {
+		n := q.uint32()
+		typ := q.next(4)
+		q.next(int(n) + 4) // data and CRC
+		if string(typ) == "IEND" {
+			return
+		}
+	}
+}
+
+// parseKlipper reads Klipper's history2.lst: a CRC-32 and a byte array
+// holding the Klipper version followed by the items, most recent first.
+// Text items are imported, URL items as their space-separated URLs, as
+// Klipper pastes them; images are skipped.
+func parseKlipper(data []byte) ([]string, int, error) {
+	file := &qstream{data: data}
+	sum := file.uint32()
+	body := file.bytes()
+	if file.err != nil {
+		return nil, 0, file.err
+	}
+	if crc32.ChecksumIEEE(body) != sum {
+		return nil, 0, errors.New("checksum mismatch")
+	}
+	q := &qstream{data: body}
+	q.bytes() // version
+	var contents []string
+	skipped := 0
+	for q.err == nil && len(q.data) > 0 {
+		switch typ := q.string(); typ {
+		case "string":
+			contents = append(contents, q.string())
+		case "url":
+			urls := make([]string, q.count(4))
+			for i := range urls {
+				urls[i] = string(q.bytes())
+			}
+			for n := q.count(8); n > 0; n-- {
+				q.string() // metadata key
+				q.string() // and value
+			}
+			q.uint32() // cut
+			contents = append(contents, strings.Join(urls, " "))
+		case "image":
+			if q.uint32() != 0 {
+				q.skipPNG()
+			}
+			skipped++
+		default:
+			if q.err == nil {
+				return nil, 0, fmt.Errorf("unknown item type %q", typ)
+			}
+		}
+	}
+	if q.err != nil {
+		return nil, 0, q.err
+	}
+	return contents, skipped, nil
+}
@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/png"
+	"slices"
+	"testing"
+	"unicode/utf16"
+)
+
+// qwriter builds QDataStream data for Klipper fixtures.
+type qwriter struct {
+	bytes.Buffer
+}
+
+func (w *qwriter) uint32(n uint32) *qwriter {
+	binary.Write(&w.Buffer, binary.BigEndian, n)
+	return w
+}
+
+func (w *qwriter) bytes(b []byte) *qwriter {
+	w.uint32(uint32(len(b)))
+	w.Write(b)
+	return w
+}
+
+func (w *qwriter) string(s string) *qwriter {
+	units := utf16.Encode([]rune(s))
+	w.uint32(uint32(2 * len(units)))
+	binary.Write(&w.Buffer, binary.BigEndian, units)
+	return w
+}
+
+// klipperFile wraps a history body the way Klipper saves it.
+func klipperFile(body []byte) []byte {
+	var file qwriter
+	file.uint32(crc32.ChecksumIEEE(body)).bytes(body)
+	return file.Bytes()
+}
+
+// klipperBody returns a history with a text item, an image, a URL item and
+// another text item, most recent first.
+func klipperBody(t *testing.T) []byte {
+	t.Helper()
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	var body qwriter
+	body.bytes([]byte("5.27.0\x00"))
+	body.string("string").string("newest ✓")
+	body.string("image").uint32(1)
+	body.Write(img.Bytes())
+	body.string("url").uint32(2).bytes([]byte("file:///tmp/a")).bytes([]byte("file:///tmp/b"))
+	body.uint32(1).string("application/x-kde-cutselection").string("0")
+	body.uint32(0)
+	body.string("string").string("oldest")
+	return body.Bytes()
+}
+
+// contentsOf returns the contents of the imported entries in order.
+func contentsOf(imp *Imported) []string {
+	var contents []string
+	for _, e := range imp.Data.History {
+		contents = append(contents, e.Content)
+	}
+	return contents
+}
+
+func TestParseKlipper(t *testing.T) {
+	body := klipperBody(t)
+	imp, err := ParseForeign(klipperFile(body), ManagerKlipper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"newest ✓", "file:///tmp/a file:///tmp/b", "oldest"}
+	if got := contentsOf(imp); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if imp.Skipped != 1 {
+		t.Errorf("skipped %d items, want the image", imp.Skipped)
+	}
+	for _, e := range imp.Data.History {
+		if e.Source != ManagerKlipper {
+			t.Errorf("entry %q has source %q", e.Content, e.Source)
+		}
+	}
+}
+
+func TestParseKlipperDamaged(t *testing.T) {
+	body := klipperBody(t)
+	file := klipperFile(body)
+	badCRC := slices.Clone(file)
+	badCRC[len(badCRC)-1] ^= 0xff
+	tests := []struct {
+		name string
+		data []byte
+		want error // nil for any error
+	}{
+		{"truncated file", file[:len(file)-5], errTruncated},
+		{"truncated body", klipperFile(body[:len(body)-3]), errTruncated},
+		{"cut inside the image", klipperFile(body[:len(body)/2]), nil},
+		{"bad checksum", badCRC, nil},
+		{"empty", nil, errTruncated},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseForeign(tt.data, ManagerKlipper)
+			if err == nil {
+				t.Fatal("damaged file parsed without error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseGPaste(t *testing.T) {
+	data := []byte(`<?xml version="1.0" encoding="UTF-8"?>
+<history version="2.0">
+  <item kind="Text" date="1"><value><![CDATA[newest <b>]]></value></item>
+  <item kind="Image" date="2"><value><![CDATA[/tmp/image.png]]></value></item>
+  <item kind="Uris" date="3"><value><![CDATA[file:///tmp/a]]></value></item>
+  <item kind="Password" date="4" name="mail"><value><![CDATA[hunter2]]></value></item>
+  <item kind="Text" date="5"><value><![CDATA[newest <b>]]></value></item>
+  <item kind="Text">old format</item>
+</history>`)
+	imp, err := ParseForeign(data, ManagerGPaste)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"newest <b>", "file:///tmp/a", "old format"}
+	if got := contentsOf(imp); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	// The image, the password and the duplicate
+	if imp.Skipped != 3 {
+		t.Errorf("skipped %d items, want 3", imp.Skipped)
+	}
+	if _, err := ParseForeign(data[:len(data)/2], ManagerGPaste); err == nil {
+		t.Error("truncated history.xml parsed without error")
+	}
+}
+
+func TestParseClipman(t *testing.T) {
+	imp, err := ParseForeign([]byte(`["oldest", "", "middle", "newest"]`), ManagerClipman)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"newest", "middle", "oldest"}
+	if got := contentsOf(imp); !slices.Equal(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if imp.Skipped != 1 {
+		t.Errorf("skipped %d items, want the empty one", imp.Skipped)
+	}
+}
@@ -0,0 +1,278 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrCorrupt matches every *CorruptError.
+var ErrCorrupt = errors.New("corrupt history file")
+
+// CorruptError reports a history file that cannot be decoded.
+type CorruptError struct {
+	Path string
+	Err  error
+}
+
+func (e *CorruptError) Error() string {
+	return fmt.Sprintf("corrupt history file %s: %v (run clipcli fsck)", e.Path, e.Err)
+}
+
+func (e *CorruptError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrCorrupt) true for any *CorruptError.
+func (e *CorruptError) Is(target error) bool {
+	return target == ErrCorrupt
+}
+
+// FsckReport describes what Fsck found and, unless it was a dry run, fixed.
+type FsckReport struct {
+	Corrupt     bool     // the history file could not be decoded
+	Salvaged    int      // entries recovered from a corrupt file
+	Problems    []string // inconsistencies found
+	Quarantined string   // where the corrupt file was moved
+	Entries     int      // entries in the resulting history
+}
+
+// OK reports whether nothing was wrong.
+func (r *FsckReport) OK() bool {
+	return !r.Corrupt && len(r.Problems) == 0
+}
+
+// Fsck checks the history for damage and inconsistencies. Unless dryRun is
+// set it repairs them: a corrupt history file is moved aside and replaced by
+// one holding every entry that could be salvaged from it, and the repaired
+// history is written out as a fresh snapshot.
+func (s *FileStore) Fsck(dryRun bool) (*FsckReport, error) {
+	lock, err := acquireLock(s.dir, true, LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	c, err := s.activeCipher()
+	if err != nil {
+		return nil, err
+	}
+	report := &FsckReport{}
+	st := &journalState{
+		snapshotPath: filepath.Join(s.dir, HistoryFileName),
+		journalPath:  filepath.Join(s.dir, JournalFileName),
+		cipher:       c,
+	}
+	raw, err := os.ReadFile(st.snapshotPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var clipData *ClipboardData
+	// An empty file is as corrupt as readSnapshot finds it; only a missing
+	// one is an empty history
+	if os.IsNotExist(err) {
+		clipData = newClipboardData()
+	} else {
+		clipData, st.generation, _, err = decodeSnapshot(st.snapshotPath, raw, c)
+		var corrupt *CorruptError
+		if errors.As(err, &corrupt) {
+			report.Corrupt = true
+			if clipData, st.generation, err = salvageSnapshot(raw, c); err != nil {
+				return nil, fmt.Errorf("%s: %w", st.snapshotPath, err)
+			}
+			report.Salvaged = len(clipData.History)
+			report.Problems = append(report.Problems, fmt.Sprintf("history file: %v", corrupt.Err))
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if err := replayJournal(clipData, st); err != nil {
+		return nil, err
+	}
+	if st.damaged > 0 {
+		report.Problems = append(report.Problems, fmt.Sprintf("journal: %d damaged records skipped", st.damaged))
+	}
+	if st.fileLen > st.validLen {
+		report.Problems = append(report.Problems,
+			fmt.Sprintf("journal: %d damaged bytes after the last valid record", st.fileLen-st.validLen))
+	}
+	report.Problems = append(report.Problems, checkConsistency(s.dir, clipData, c)...)
+	report.Entries = len(clipData.History)
+	if dryRun || report.OK() {
+		return report, nil
+	}
+
+	if report.Corrupt {
+		report.Quarantined = fmt.Sprintf("%s.corrupt-%s", st.snapshotPath, time.Now().Format("20060102T150405"))
+		if err := os.Rename(st.snapshotPath, report.Quarantined); err != nil {
+			return report, fmt.Errorf("quarantine history file: %w", err)
+		}
+	}
+	if err := compactJournal(st, clipData); err != nil {
+		return report, fmt.Errorf("write repaired history: %w", err)
+	}
+	return report, sweepBlobs(s.dir, clipData)
+}
+
+// salvageSnapshot recovers every complete entry, and whatever pins, trash
+// and generation precede the damage, from a corrupt history file. An
+// encrypted file cannot be partially decrypted, so nothing is recovered
+// from it. A file from a newer version is left alone.
+func salvageSnapshot(raw []byte, c *Cipher) (*ClipboardData, int64, error) {
+	data, err := openFile(c, raw)
+	if err != nil {
+		return newClipboardData(), 0, nil
+	}
+	doc := salvageDoc(data)
+	if err := migrate(doc, time.Now()); err != nil {
+		if errors.Is(err, ErrSchemaTooNew) {
+			return nil, 0, err
+		}
+		return newClipboardData(), 0, nil
+	}
+	clipData, err := decodeClipboardData(doc)
+	if err != nil {
+		return newClipboardData(), 0, nil
+	}
+	return clipData, doc.Generation, nil
+}
+
+// salvageDoc decodes as much of a damaged history document as possible,
+// keeping only history items of the document's shape.
+func salvageDoc(data []byte) *historyDoc {
+	doc := &historyDoc{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	versioned := false
+
+	tok, err := dec.Token()
+	if err != nil {
+		return doc
+	}
+	if tok == json.Delim('[') {
+		doc.History = salvageItems(dec)
+	} else if tok == json.Delim('{') {
+	fields:
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				break
+			}
+			switch key {
+			case "version":
+				if dec.Decode(&doc.Version) != nil {
+					break fields
+				}
+				versioned = true
+			case "generation":
+				if dec.Decode(&doc.Generation) != nil {
+					break fields
+				}
+			case "history":
+				if tok, err := dec.Token(); err != nil || tok != json.Delim('[') {
+					break fields
+				}
+				doc.History = salvageItems(dec)
+				if _, err := dec.Token(); err != nil {
+					break fields // the array was cut short
+				}
+			case "pinned":
+				if dec.Decode(&doc.Pinned) != nil {
+					break fields
+				}
+			case "trash":
+				if dec.Decode(&doc.Trash) != nil {
+					break fields
+				}
+			default:
+				var skip json.RawMessage
+				if dec.Decode(&skip) != nil {
+					break fields
+				}
+			}
+		}
+	}
+
+	// Keep the items matching the shape of the first one
+	var items []json.RawMessage
+	asStrings := len(doc.History) > 0 && doc.History[0][0] == '"'
+	for _, item := range doc.History {
+		if asStrings {
+			var text string
+			if json.Unmarshal(item, &text) == nil {
+				items = append(items, item)
+			}
+			continue
+		}
+		var e Entry
+		if json.Unmarshal(item, &e) == nil && e.ID != "" {
+			items = append(items, item)
+		}
+	}
+	doc.History = items
+	if !versioned {
+		doc.Version = schemaEntries
+		if asStrings {
+			doc.Version = schemaStrings
+		}
+	}
+	return doc
+}
+
+// salvageItems decodes array elements until the array ends or breaks off.
+func salvageItems(dec *json.Decoder) []json.RawMessage {
+	var items []json.RawMessage
+	for dec.More() {
+		var item json.RawMessage
+		if err := dec.Decode(&item); err != nil {
+			break
+		}
+		items = append(items, bytes.TrimSpace(item))
+	}
+	return items
+}
+
+// checkConsistency finds and fixes problems in decodable history: entries
+// whose ID does not match their content, duplicate IDs, pins without an
+// entry and entries whose blob is missing or unreadable.
+func checkConsistency(dir string, clipData *ClipboardData, c *Cipher) []string {
+	var problems []string
+	seen := make(map[string]bool, len(clipData.History))
+	kept := clipData.History[:0]
+	for _, e := range clipData.History {
+		if e.Blob != "" {
+			if _, err := readBlob(dir, e.Blob, c); err != nil {
+				problems = append(problems, fmt.Sprintf("entry %s: %v; keeping its preview", e.ID, err))
+				e.Blob = ""
+				e.Size = len(e.Content)
+			}
+		}
+		if e.Blob == "" {
+			if id := HashID(e.Content); id != e.ID {
+				problems = append(problems, fmt.Sprintf("entry %s: ID does not match content, now %s", e.ID, id))
+				if clipData.Pinned[e.ID] {
+					delete(clipData.Pinned, e.ID)
+					clipData.Pinned[id] = true
+				}
+				e.ID = id
+			}
+		}
+		if seen[e.ID] {
+			problems = append(problems, fmt.Sprintf("entry %s: duplicate removed", e.ID))
+			continue
+		}
+		seen[e.ID] = true
+		kept = append(kept, e)
+	}
+	clipData.History = kept
+	for id := range clipData.Pinned {
+		if !seen[id] {
+			problems = append(problems, fmt.Sprintf("pin %s: no such entry, removed", id))
+			delete(clipData.Pinned, id)
+		}
+	}
+	return problems
+}
@@ -0,0 +1,110 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFsckEmptyHistoryFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, HistoryFileName), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFileStore(dir)
+	if _, err := s.Add(Entry{Content: "before repair"}); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("Add on an empty history file: got %v, want ErrCorrupt", err)
+	}
+
+	report, err := s.Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.Corrupt || report.Quarantined == "" {
+		t.Fatalf("empty history file not reported and quarantined: %+v", report)
+	}
+	if _, err := os.Stat(report.Quarantined); err != nil {
+		t.Errorf("quarantined file: %v", err)
+	}
+	if _, err := s.Add(Entry{Content: "after repair"}); err != nil {
+		t.Fatalf("Add after repair: %v", err)
+	}
+	if report, err = s.Fsck(true); err != nil || !report.OK() {
+		t.Errorf("second fsck: %+v, %v", report, err)
+	}
+}
+
+func TestFsckMissingHistoryFile(t *testing.T) {
+	report, err := NewFileStore(t.TempDir()).Fsck(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() {
+		t.Errorf("missing history file reported: %+v", report)
+	}
+}
+
+func TestRekeyHistoryCopies(t *testing.T) {
+	dir := t.TempDir()
+	legacy := []byte(`["legacy entry"]`)
+	if err := os.WriteFile(filepath.Join(dir, HistoryFileName), legacy, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := NewFileStore(dir)
+	// Migrating keeps a .v0.bak copy
+	if _, err := s.List(); err != nil {
+		t.Fatal(err)
+	}
+	quarantined := filepath.Join(dir, HistoryFileName+".corrupt-20260101T000000")
+	if err := os.WriteFile(quarantined, []byte(`{"history": [`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	copies, err := historyCopies(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(copies) != 2 {
+		t.Fatalf("got copies %q, want a migration backup and a quarantine", copies)
+	}
+	plain := make(map[string][]byte)
+	for _, p := range copies {
+		if plain[p], err = os.ReadFile(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := s.Encrypt(KeySourceKeyfile, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Copies) != 2 {
+		t.Errorf("encrypt reported copies %q, want 2", report.Copies)
+	}
+	for _, p := range copies {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(data, encryptedMagic) {
+			t.Errorf("%s left in plaintext after encrypt", filepath.Base(p))
+		}
+	}
+
+	if report, err = s.Decrypt(); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Copies) != 2 {
+		t.Errorf("decrypt reported copies %q, want 2", report.Copies)
+	}
+	for _, p := range copies {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(data, plain[p]) {
+			t.Errorf("%s not restored after decrypt: %q", filepath.Base(p), data)
+		}
+	}
+}
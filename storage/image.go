@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif" // register decoders for DecodeConfig
+	_ "image/jpeg"
+	_ "image/png"
+	"net/http"
+	"strings"
+)
+
+// ImageMIME returns the MIME type of image data, or "" if data is not an
+// image format browsers recognise.
+func ImageMIME(data []byte) string {
+	mime := http.DetectContentType(data)
+	if !strings.HasPrefix(mime, "image/") {
+		return ""
+	}
+	return mime
+}
+
+// imageSize returns the pixel dimensions of an image, or zeros for formats
+// that cannot be decoded.
+func imageSize(data string) (width, height int) {
+	cfg, _, err := image.DecodeConfig(strings.NewReader(data))
+	if err != nil {
+		return 0, 0
+	}
+	return cfg.Width, cfg.Height
+}
+
+// IsBinary reports whether the entry holds binary content of type MIME
+// rather than text.
+func (e Entry) IsBinary() bool {
+	return e.MIME != ""
+}
+
+// Describe returns a one-line description of binary content, such as
+// "Image 1920×1080 PNG".
+func (e Entry) Describe() string {
+	format, _, _ := strings.Cut(strings.TrimPrefix(e.MIME, "image/"), "+")
+	format = strings.ToUpper(format)
+	if !strings.HasPrefix(e.MIME, "image/") {
+		return fmt.Sprintf("Binary %s %s", e.MIME, FormatSize(e.Size))
+	}
+	if e.Width == 0 || e.Height == 0 {
+		return "Image " + format
+	}
+	return fmt.Sprintf("Image %d×%d %s", e.Width, e.Height, format)
+}
+
+// DisplayText returns what lists and previews show for the entry: the
+// mask of secrets, the description of binary content and its (possibly
+// preview) content otherwise.
+func (e Entry) DisplayText() string {
+	if e.Sensitive() {
+		return e.Mask()
+	}
+	if e.IsBinary() {
+		return e.Describe()
+	}
+	return e.Content
+}
@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FormatHistory is the import format of a raw history file.
+const FormatHistory = "history"
+
+// SourceImport is recorded on imported entries that carry no source.
+const SourceImport = "import"
+
+// ImportFormats lists the formats ParseImport reads.
+var ImportFormats = append(append([]string(nil), ExportFormats...), FormatHistory)
+
+// Imported is history parsed from an export or history file, most recent
+// first.
+type Imported struct {
+	Data    *ClipboardData
+	Skipped int // items that could not be imported
+}
+
+// ParseImport reads history in the given format, or detects the format if
+// it is empty. Entry IDs are derived from the content, so the same content
+// always dedupes; items without timestamps keep them zero.
+func ParseImport(data []byte, format string) (*Imported, error) {
+	if format == "" {
+		var err error
+		if format, err = detectFormat(data); err != nil {
+			return nil, err
+		}
+	}
+	var recs []ExportRecord
+	var err error
+	switch format {
+	case FormatJSON:
+		err = json.Unmarshal(data, &recs)
+	case FormatNDJSON:
+		recs, err = parseNDJSON(data)
+	case FormatCSV:
+		recs, err = parseCSV(data)
+	case FormatMarkdown:
+		recs, err = parseMarkdown(data)
+	case FormatRaw0:
+		// Exports are oldest first, imported history most recent first
+		contents := strings.Split(string(data), "\x00")
+		for i := len(contents) - 1; i >= 0; i-- {
+			if contents[i] != "" {
+				recs = append(recs, ExportRecord{Content: contents[i]})
+			}
+		}
+	case FormatHistory:
+		return parseHistoryFile(data)
+	default:
+		return nil, fmt.Errorf("unknown import format %q (want one of %s)", format, strings.Join(ImportFormats, ", "))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", format, err)
+	}
+	imp := &Imported{Data: newClipboardData()}
+	for _, r := range recs {
+		if r.Content == "" {
+			imp.Skipped++
+			continue
+		}
+		e := Entry{
+			ID:        HashID(r.Content),
+			Content:   r.Content,
+			Type:      r.Type,
+			Size:      len(r.Content),
+			Source:    r.Source,
+			Selection: r.Selection,
+			FirstSeen: r.FirstSeen,
+			LastSeen:  r.LastSeen,
+			UseCount:  r.UseCount,
+		}
+		if e.Type == "" {
+			e.Type = DetectType(e.Content)
+		}
+		if e.Source == "" {
+			e.Source = SourceImport
+		}
+		imp.Data.History = append(imp.Data.History, e)
+		if r.Pinned {
+			imp.Data.Pinned[e.ID] = true
+		}
+	}
+	return imp, nil
+}
+
+// detectFormat recognises the export formats and raw history files.
+func detectFormat(data []byte) (string, error) {
+	if bytes.HasPrefix(data, encryptedMagic) {
+		return "", errors.New("encrypted history files cannot be imported; export the history instead")
+	}
+	trimmed := bytes.TrimSpace(data)
+	switch {
+	case bytes.IndexByte(data, 0) >= 0:
+		return FormatRaw0, nil
+	case bytes.HasPrefix(trimmed, []byte("# Clipboard history")):
+		return FormatMarkdown, nil
+	case bytes.HasPrefix(trimmed, []byte(strings.Join(csvHeader[:3], ","))):
+		return FormatCSV, nil
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		// An array of records is an export; one of strings the oldest history file
+		var items []json.RawMessage
+		if err := json.Unmarshal(trimmed, &items); err == nil && len(items) > 0 && items[0][0] == '"' {
+			return FormatHistory, nil
+		}
+		return FormatJSON, nil
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		line, _, _ := bytes.Cut(trimmed, []byte("\n"))
+		var probe map[string]json.RawMessage
+		if json.Unmarshal(line, &probe) == nil && probe["content"] != nil {
+			return FormatNDJSON, nil
+		}
+		return FormatHistory, nil
+	}
+	return "", errors.New("unrecognised import format; pass --format")
+}
+
+// parseNDJSON decodes one record per line.
+func parseNDJSON(data []byte) ([]ExportRecord, error) {
+	var recs []ExportRecord
+	dec := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var r ExportRecord
+		if err := dec.Decode(&r); err == io.EOF {
+			return recs, nil
+		} else if err != nil {
+			return nil, err
+		}
+		recs = append(recs, r)
+	}
+}
+
+// parseCSV decodes rows by the names in the header row, so columns may be
+// reordered or missing.
+func parseCSV(data []byte) ([]ExportRecord, error) {
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	col := make(map[string]int)
+	for i, name := range rows[0] {
+		col[name] = i
+	}
+	if _, ok := col["content"]; !ok {
+		return nil, errors.New("no content column")
+	}
+	field := func(row []string, name string) string {
+		if i, ok := col[name]; ok && i < len(row) {
+			return row[i]
+		}
+		return ""
+	}
+	var recs []ExportRecord
+	for _, row := range rows[1:] {
+		r := ExportRecord{
+			Type:      field(row, "type"),
+			Source:    field(row, "source"),
+			Selection: field(row, "selection"),
+			Content:   field(row, "content"),
+		}
+		// encoding/csv drops the carriage returns of CRLF line breaks even
+		// inside quotes; the ID tells whether the content had them
+		if crlf := strings.ReplaceAll(r.Content, "\n", "\r\n"); crlf != r.Content &&
+			HashID(r.Content) != field(row, "id") && HashID(crlf) == field(row, "id") {
+			r.Content = crlf
+		}
+		r.Pinned, _ = strconv.ParseBool(field(row, "pinned"))
+		r.UseCount, _ = strconv.Atoi(field(row, "use_count"))
+		r.FirstSeen, _ = time.Parse(time.RFC3339Nano, field(row, "first_seen"))
+		r.LastSeen, _ = time.Parse(time.RFC3339Nano, field(row, "last_seen"))
+		recs = append(recs, r)
+	}
+	return recs, nil
+}
+
+// parseMarkdown reads back the sections written by exportMarkdown.
+func parseMarkdown(data []byte) ([]ExportRecord, error) {
+	var recs []ExportRecord
+	var r *ExportRecord
+	var id, fence string
+	var body []string
+	for _, raw := range strings.Split(string(data), "\n") {
+		// Content keeps its carriage returns; the markup around it may have
+		// gained some in transit
+		line := strings.TrimSuffix(raw, "\r")
+		switch {
+		case fence != "":
+			if line != fence {
+				body = append(body, raw)
+				continue
+			}
+			// exportMarkdown ends content with a newline if it had none;
+			// the ID tells which it was
+			r.Content = strings.Join(body, "\n")
+			if HashID(r.Content) != id {
+				r.Content += "\n"
+			}
+			recs = append(recs, *r)
+			r, fence, body = nil, "", nil
+		case strings.HasPrefix(line, "## "):
+			title := strings.TrimPrefix(line, "## ")
+			var meta string
+			id, meta, _ = strings.Cut(title, " (")
+			meta = strings.TrimSuffix(meta, ")")
+			typ, flags, _ := strings.Cut(meta, ", ")
+			r = &ExportRecord{Type: typ, Pinned: flags == "pinned"}
+		case r == nil:
+		case strings.HasPrefix(line, "- first seen: "):
+			r.FirstSeen, _ = time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "- first seen: "))
+		case strings.HasPrefix(line, "- last seen: "):
+			r.LastSeen, _ = time.Parse(time.RFC3339Nano, strings.TrimPrefix(line, "- last seen: "))
+		case strings.HasPrefix(line, "- selection: "):
+			r.Selection = strings.TrimPrefix(line, "- selection: ")
+		case strings.HasPrefix(line, "- uses: "):
+			r.UseCount, _ = strconv.Atoi(strings.TrimPrefix(line, "- uses: "))
+		case strings.HasPrefix(line, "```"):
+			fence = line
+		}
+	}
+	if fence != "" {
+		return nil, errors.New("unterminated code fence")
+	}
+	return recs, nil
+}
+
+// parseHistoryFile reads a raw history file of any schema version. Entries
+// whose content was moved to a blob only have a preview in the file and are
+// skipped.
+func parseHistoryFile(data []byte) (*Imported, error) {
+	doc, err := parseHistoryDoc(data)
+	if err != nil {
+		return nil, fmt.Errorf("parse history file: %w", err)
+	}
+	if err := migrate(doc, time.Now()); err != nil {
+		return nil, err
+	}
+	clipData, err := decodeClipboardData(doc)
+	if err != nil {
+		return nil, fmt.Errorf("parse history file: %w", err)
+	}
+	imp := &Imported{Data: newClipboardData()}
+	for _, e := range clipData.History {
+		if e.Blob != "" {
+			imp.Skipped++
+			continue
+		}
+		imp.Data.History = append(imp.Data.History, e)
+		if clipData.Pinned[e.ID] {
+			imp.Data.Pinned[e.ID] = true
+		}
+	}
+	return imp, nil
+}
+
+// Import merges imported history into cd (see Merge), or with replace set
+// first moves the current history to the trash. New entries without
+// timestamps are stamped with now, each a millisecond before the one above
+// it, so they keep their order.
+func (cd *ClipboardData) Import(imp *Imported, replace bool, now time.Time) MergeReport {
+	if replace {
+		cd.DiscardAll(now)
+	}
+	other := &ClipboardData{History: make([]Entry, len(imp.Data.History)), Pinned: imp.Data.Pinned}
+	for i, e := range imp.Data.History {
+		if e.LastSeen.IsZero() && cd.IndexOf(e.ID) < 0 {
+			e.LastSeen = now.Add(-time.Duration(i) * time.Millisecond)
+		}
+		if e.FirstSeen.IsZero() && cd.IndexOf(e.ID) < 0 {
+			e.FirstSeen = e.LastSeen
+		}
+		other.History[i] = e
+	}
+	report := cd.Merge(other)
+	report.Skipped += imp.Skipped
+	return report
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestExportImportRoundTrip(t *testing.T) {
+	seen := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	contents := []string{
+		"plain text",
+		"windows lines\r\nsecond line\r\n",
+		"trailing carriage return\r",
+		"no final newline\nbut two lines",
+		"ends with a newline\n",
+		"```go\nfenced code\n```",
+		`comma, "quotes" and ünïcödé`,
+		"https://example.com/path?q=1",
+	}
+	var recs []ExportRecord
+	for i, c := range contents {
+		e := NewEntry(c, SourceDaemon, seen.Add(time.Duration(i)*time.Minute))
+		e.UseCount = i
+		if i%3 == 0 {
+			e.Selection = SelectionPrimary
+		}
+		recs = append(recs, NewExportRecord(e, c, i%2 == 0))
+	}
+	SortExport(recs)
+
+	for _, format := range ExportFormats {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := Export(&buf, format, recs); err != nil {
+				t.Fatal(err)
+			}
+			imp, err := ParseImport(buf.Bytes(), "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imp.Skipped != 0 || len(imp.Data.History) != len(recs) {
+				t.Fatalf("imported %d entries, skipped %d; want %d", len(imp.Data.History), imp.Skipped, len(recs))
+			}
+			byID := make(map[string]Entry)
+			for _, e := range imp.Data.History {
+				byID[e.ID] = e
+			}
+			for _, r := range recs {
+				e, ok := byID[r.ID]
+				if !ok {
+					t.Errorf("%q did not survive the round trip", r.Content)
+					continue
+				}
+				if e.Content != r.Content {
+					t.Errorf("content %q came back as %q", r.Content, e.Content)
+				}
+				// Raw0 holds nothing but the content
+				if format == FormatRaw0 {
+					continue
+				}
+				if !e.LastSeen.Equal(r.LastSeen) || e.UseCount != r.UseCount || e.Selection != r.Selection ||
+					imp.Data.Pinned[e.ID] != r.Pinned {
+					t.Errorf("metadata of %q came back as %+v, pinned %v", r.Content, e, imp.Data.Pinned[e.ID])
+				}
+			}
+		})
+	}
+}
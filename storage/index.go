@@ -0,0 +1,270 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// IndexFileName is the name of the search index file.
+const IndexFileName = "search_index.gob"
+
+// indexVersion changes whenever the index layout or tokenisation does;
+// index files of another version are rebuilt.
+const indexVersion = 1
+
+// Index is an inverted index from the trigrams of lowercased entry content
+// to the entries containing them. It covers the full content of blob
+// entries, not just their previews.
+type Index struct {
+	Version  int
+	IDs      []string            // document number to entry ID; "" once removed
+	Postings map[uint32][]uint32 // trigram to ascending document numbers
+
+	docs    map[string]uint32 // entry ID to document number
+	removed int               // number of removed documents still in IDs
+}
+
+// newIndex returns an empty index.
+func newIndex() *Index {
+	return &Index{Version: indexVersion, Postings: make(map[uint32][]uint32), docs: make(map[string]uint32)}
+}
+
+// trigrams returns the distinct trigrams of s, lowercased.
+func trigrams(s string) map[uint32]bool {
+	s = strings.ToLower(s)
+	grams := make(map[uint32]bool)
+	for i := 0; i+3 <= len(s); i++ {
+		grams[uint32(s[i])<<16|uint32(s[i+1])<<8|uint32(s[i+2])] = true
+	}
+	return grams
+}
+
+// add indexes content under id.
+func (ix *Index) add(id, content string) {
+	n := uint32(len(ix.IDs))
+	ix.IDs = append(ix.IDs, id)
+	ix.docs[id] = n
+	for g := range trigrams(content) {
+		ix.Postings[g] = append(ix.Postings[g], n)
+	}
+}
+
+// remove drops id from the index. Its postings stay behind until the index
+// is rebuilt; Candidates skips them.
+func (ix *Index) remove(id string) {
+	n, ok := ix.docs[id]
+	if !ok {
+		return
+	}
+	ix.IDs[n] = ""
+	delete(ix.docs, id)
+	ix.removed++
+}
+
+// Len returns the number of indexed entries.
+func (ix *Index) Len() int {
+	return len(ix.docs)
+}
+
+// Candidates returns the IDs of the entries whose content contains every
+// trigram of query, a superset of those containing query itself (ignoring
+// case). Queries shorter than a trigram cannot be narrowed down, which is
+// reported by ok being false.
+func (ix *Index) Candidates(query string) (ids map[string]bool, ok bool) {
+	grams := trigrams(query)
+	if len(grams) == 0 {
+		return nil, false
+	}
+	// Intersect the postings, starting from the rarest trigram
+	var lists [][]uint32
+	for g := range grams {
+		list := ix.Postings[g]
+		if len(list) == 0 {
+			return map[string]bool{}, true
+		}
+		lists = append(lists, list)
+	}
+	shortest := 0
+	for i, list := range lists {
+		if len(list) < len(lists[shortest]) {
+			shortest = i
+		}
+	}
+	docs := lists[shortest]
+	for i, list := range lists {
+		if i != shortest {
+			docs = intersect(docs, list)
+		}
+	}
+	ids = make(map[string]bool, len(docs))
+	for _, n := range docs {
+		if id := ix.IDs[n]; id != "" {
+			ids[id] = true
+		}
+	}
+	return ids, true
+}
+
+// intersect returns the numbers present in both ascending lists.
+func intersect(a, b []uint32) []uint32 {
+	var out []uint32
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			out = append(out, a[i])
+			i++
+			j++
+		}
+	}
+	return out
+}
+
+// sync brings the index in line with the history: entries that are gone
+// are removed and new ones added, their text content read with content. An index
+// holding more removed documents than live ones is rebuilt from scratch. It
+// reports whether anything changed.
+func (ix *Index) sync(clipData *ClipboardData, content func(Entry) (string, error)) (*Index, bool, error) {
+	live := make(map[string]bool, len(clipData.History))
+	for _, e := range clipData.History {
+		live[e.ID] = true
+	}
+	changed := false
+	for id := range ix.docs {
+		if !live[id] {
+			ix.remove(id)
+			changed = true
+		}
+	}
+	if ix.removed > len(ix.docs) {
+		ix, changed = newIndex(), true
+	}
+	for _, e := range clipData.History {
+		if _, ok := ix.docs[e.ID]; ok {
+			continue
+		}
+		// Binary content is found by its description; secrets are kept
+		// out of the index file
+		text := e.DisplayText()
+		if !e.IsBinary() && !e.Sensitive() {
+			var err error
+			if text, err = content(e); err != nil {
+				return ix, changed, err
+			}
+		}
+		ix.add(e.ID, text)
+		changed = true
+	}
+	return ix, changed, nil
+}
+
+// readIndex loads the index file in dir. A missing, unreadable or outdated
+// file yields an empty index, so it is rebuilt.
+func readIndex(dir string, c *Cipher) *Index {
+	data, err := os.ReadFile(filepath.Join(dir, IndexFileName))
+	if err != nil {
+		return newIndex()
+	}
+	if data, err = openFile(c, data); err != nil {
+		return newIndex()
+	}
+	ix := &Index{}
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(ix); err != nil || ix.Version != indexVersion {
+		return newIndex()
+	}
+	if ix.Postings == nil {
+		ix.Postings = make(map[uint32][]uint32)
+	}
+	ix.docs = make(map[string]uint32, len(ix.IDs))
+	for n, id := range ix.IDs {
+		if id == "" {
+			ix.removed++
+			continue
+		}
+		ix.docs[id] = uint32(n)
+	}
+	return ix
+}
+
+// writeIndex saves ix to the index file in dir, sealed when c is set.
+func writeIndex(dir string, ix *Index, c *Cipher) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(ix); err != nil {
+		return err
+	}
+	data := buf.Bytes()
+	if c != nil {
+		data = sealFile(c, data)
+	}
+	return writeFileAtomic(filepath.Join(dir, IndexFileName), data)
+}
+
+// updateIndex brings the index file in dir up to date with clipData and
+// returns it. The caller must hold the exclusive lock.
+func updateIndex(dir string, clipData *ClipboardData, c *Cipher) (*Index, error) {
+	ix, changed, err := readIndex(dir, c).sync(clipData, func(e Entry) (string, error) {
+		if e.Blob == "" {
+			return e.Content, nil
+		}
+		return readBlob(dir, e.Blob, c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		if err := writeIndex(dir, ix, c); err != nil {
+			return nil, err
+		}
+	}
+	return ix, nil
+}
+
+// removeIndex deletes the index file in dir; the next search rebuilds it.
+func removeIndex(dir string) {
+	if err := os.Remove(filepath.Join(dir, IndexFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("remove search index: %v\n", err)
+	}
+}
+
+// Index returns the search index of the history, updating or rebuilding the
+// index file first if it is missing or stale. Writes leave the index alone,
+// so it only catches up here, when it is searched.
+func (s *FileStore) Index() (*Index, error) {
+	lock, err := acquireLock(s.dir, true, LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+
+	c, err := s.activeCipher()
+	if err != nil {
+		return nil, err
+	}
+	clipData, _, err := loadState(s.dir, c)
+	if err != nil {
+		return nil, err
+	}
+	return updateIndex(s.dir, clipData, c)
+}
+
+// OpenIndex returns a search index for the history in s: the persistent one
+// of a FileStore, or one built in memory for other stores.
+func OpenIndex(s Store) (*Index, error) {
+	if fs, ok := s.(*FileStore); ok {
+		return fs.Index()
+	}
+	clipData, err := Snapshot(s)
+	if err != nil {
+		return nil, err
+	}
+	ix, _, err := newIndex().sync(clipData, s.Content)
+	return ix, err
+}
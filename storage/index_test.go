@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexCatchesUpOnSearch(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	addAll(t, s, "foobar", "barbaz")
+	// Writes do not touch the index
+	if _, err := os.Stat(filepath.Join(dir, IndexFileName)); !os.IsNotExist(err) {
+		t.Fatalf("index written on update: %v", err)
+	}
+
+	candidates := func(query string) map[string]bool {
+		t.Helper()
+		ix, err := s.Index()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids, ok := ix.Candidates(query)
+		if !ok {
+			t.Fatalf("query %q not narrowed down", query)
+		}
+		return ids
+	}
+	if ids := candidates("BAR"); len(ids) != 2 {
+		t.Errorf("candidates for BAR: %v, want both entries", ids)
+	}
+	if err := s.Delete(HashID("foobar")); err != nil {
+		t.Fatal(err)
+	}
+	addAll(t, s, "foolish")
+	if ids := candidates("foo"); len(ids) != 1 || !ids[HashID("foolish")] {
+		t.Errorf("candidates for foo: %v, want only the new entry", ids)
+	}
+}
@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// JournalFileName is the name of the append-only history journal.
+const JournalFileName = "clip_history.journal"
+
+// CompactRatio is the share of superseded journal records above which the
+// journal is folded back into the history file.
+var CompactRatio = 0.5
+
+// minCompactRecords keeps tiny journals from being compacted constantly.
+const minCompactRecords = 64
+
+// Journal record operations.
+const (
+	opBegin = "begin" // first record; ties the journal to a snapshot generation
+	opPut   = "put"   // insert or replace an entry and move it to the top
+	opSet   = "set"   // replace an entry's metadata in place
+	opDel   = "del"
+	opPin   = "pin"
+	opUnpin = "unpin"
+	opClear = "clear"
+	opTrash = "trash" // replace the trash section
+)
+
+// journalRecord is one line of the journal. On disk every record is written
+// as "<crc32 hex> <payload>\n" so torn or damaged writes can be detected. The
+// payload is the record's JSON, or "!" followed by the base64 of the sealed
+// JSON when the history is encrypted.
+type journalRecord struct {
+	Op         string         `json:"op"`
+	Generation int64          `json:"gen,omitempty"`
+	ID         string         `json:"id,omitempty"`
+	Entry      *Entry         `json:"entry,omitempty"`
+	Trash      []TrashedEntry `json:"trash,omitempty"`
+}
+
+// journalState describes the files a ClipboardData was rebuilt from.
+type journalState struct {
+	snapshotPath string
+	journalPath  string
+	generation   int64
+	version      int   // schema version the snapshot was written with
+	snapshotRecs int   // entries and pins stored in the snapshot
+	journalRecs  int   // valid records replayed from the journal
+	damaged      int   // damaged records skipped in the middle of the journal
+	validLen     int64 // journal bytes up to the last valid record
+	fileLen      int64 // journal bytes on disk, including any torn tail
+	cipher       *Cipher
+}
+
+// loadState rebuilds the clipboard data in dir from the history file plus
+// every valid journal record of the same generation. Encrypted data is opened
+// with c, which is also used for subsequent writes. Callers must hold the
+// history lock.
+func loadState(dir string, c *Cipher) (*ClipboardData, *journalState, error) {
+	snapshotPath := filepath.Join(dir, HistoryFileName)
+	journalPath := filepath.Join(dir, JournalFileName)
+	clipData, generation, version, err := readSnapshot(snapshotPath, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	st := &journalState{
+		snapshotPath: snapshotPath,
+		journalPath:  journalPath,
+		generation:   generation,
+		version:      version,
+		snapshotRecs: len(clipData.History) + len(clipData.Pinned),
+		cipher:       c,
+	}
+	if err := replayJournal(clipData, st); err != nil {
+		return nil, nil, err
+	}
+	return clipData, st, nil
+}
+
+// replayJournal applies the journal to clipData. Replay stops at a torn
+// tail, which is discarded on the next append. Records are only ever
+// appended, so a damaged record followed by others was damaged in place: it
+// is skipped and the records after it are still applied. A journal left
+// over from an older generation is ignored because its records are already
+// part of the snapshot; so is one whose first record is damaged, since its
+// generation is unknown.
+func replayJournal(clipData *ClipboardData, st *journalState) error {
+	data, err := os.ReadFile(st.journalPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	st.fileLen = int64(len(data))
+
+	var offset int64
+	first := true
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			break // torn tail: the last write never completed
+		}
+		rec, ok, err := decodeRecord(data[:nl], st.cipher)
+		if err != nil {
+			return err
+		}
+		switch {
+		case first && (!ok || rec.Op != opBegin || rec.Generation != st.generation):
+			// Stale journal from before the last compaction, or of an
+			// unknown generation
+			return nil
+		case first:
+			first = false
+		case !ok:
+			st.damaged++
+		default:
+			applyRecord(clipData, rec)
+			st.journalRecs++
+		}
+		offset += int64(nl + 1)
+		data = data[nl+1:]
+	}
+	st.validLen = offset
+	return nil
+}
+
+// decodeRecord parses and verifies a single journal line. ok is false for a
+// torn or damaged line; an error means the line is intact but cannot be
+// decrypted.
+func decodeRecord(line []byte, c *Cipher) (rec journalRecord, ok bool, err error) {
+	sum, payload, found := bytes.Cut(line, []byte(" "))
+	if !found || fmt.Sprintf("%08x", crc32.ChecksumIEEE(payload)) != string(sum) {
+		return rec, false, nil
+	}
+	if sealed, isSealed := bytes.CutPrefix(payload, []byte("!")); isSealed {
+		if c == nil {
+			return rec, false, ErrLocked
+		}
+		raw, err := base64.StdEncoding.DecodeString(string(sealed))
+		if err != nil {
+			return rec, false, nil
+		}
+		if payload, err = c.Open(raw); err != nil {
+			return rec, false, err
+		}
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, false, nil
+	}
+	return rec, true, nil
+}
+
+// encodeRecord renders a record as a checksummed journal line, sealing it
+// with c if set.
+func encodeRecord(buf *bytes.Buffer, rec journalRecord, c *Cipher) error {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if c != nil {
+		payload = []byte("!" + base64.StdEncoding.EncodeToString(c.Seal(payload)))
+	}
+	fmt.Fprintf(buf, "%08x %s\n", crc32.ChecksumIEEE(payload), payload)
+	return nil
+}
+
+// applyRecord replays a single journal record.
+func applyRecord(clipData *ClipboardData, rec journalRecord) {
+	switch rec.Op {
+	case opPut:
+		if rec.Entry == nil {
+			return
+		}
+		if i := clipData.IndexOf(rec.Entry.ID); i >= 0 {
+			clipData.History = append(clipData.History[:i], clipData.History[i+1:]...)
+		}
+		clipData.History = append([]Entry{*rec.Entry}, clipData.History...)
+	case opSet:
+		if rec.Entry == nil {
+			return
+		}
+		if i := clipData.IndexOf(rec.Entry.ID); i >= 0 {
+			clipData.History[i] = *rec.Entry
+		}
+	case opDel:
+		if i := clipData.IndexOf(rec.ID); i >= 0 {
+			clipData.Remove(i)
+		}
+	case opPin:
+		clipData.Pinned[rec.ID] = true
+	case opUnpin:
+		delete(clipData.Pinned, rec.ID)
+	case opClear:
+		clipData.Clear()
+	case opTrash:
+		clipData.Trash = rec.Trash
+	}
+}
+
+// diffRecords computes journal records that turn before into after.
+func diffRecords(before, after *ClipboardData) []journalRecord {
+	var recs []journalRecord
+	if len(after.History) == 0 && len(before.History) > 0 {
+		recs = append(recs, journalRecord{Op: opClear})
+		before = newClipboardData()
+	}
+
+	pos := make(map[string]int, len(after.History))
+	for i, e := range after.History {
+		pos[e.ID] = i
+	}
+	old := make(map[string]Entry, len(before.History))
+	var kept []Entry
+	for _, e := range before.History {
+		if _, ok := pos[e.ID]; ok {
+			kept = append(kept, e)
+			old[e.ID] = e
+		} else {
+			recs = append(recs, journalRecord{Op: opDel, ID: e.ID})
+		}
+	}
+
+	// Match the tail of the new order against the surviving old order;
+	// everything in front of the matched tail is re-put at the top.
+	i, j := len(after.History)-1, len(kept)-1
+	for i >= 0 && j >= 0 {
+		if after.History[i].ID == kept[j].ID {
+			i--
+			j--
+			continue
+		}
+		if pos[kept[j].ID] > i {
+			break
+		}
+		j-- // moved towards the top; it will be put
+	}
+	for k := i; k >= 0; k-- {
+		e := after.History[k]
+		recs = append(recs, journalRecord{Op: opPut, Entry: &e})
+	}
+	for k := i + 1; k < len(after.History); k++ {
+		e := after.History[k]
+		if !reflect.DeepEqual(old[e.ID], e) {
+			recs = append(recs, journalRecord{Op: opSet, Entry: &e})
+		}
+	}
+
+	for id := range before.Pinned {
+		if !after.Pinned[id] {
+			if _, ok := pos[id]; ok {
+				recs = append(recs, journalRecord{Op: opUnpin, ID: id})
+			}
+		}
+	}
+	for id := range after.Pinned {
+		if !before.Pinned[id] {
+			recs = append(recs, journalRecord{Op: opPin, ID: id})
+		}
+	}
+	if (len(before.Trash) > 0 || len(after.Trash) > 0) && !reflect.DeepEqual(before.Trash, after.Trash) {
+		recs = append(recs, journalRecord{Op: opTrash, Trash: after.Trash})
+	}
+	return recs
+}
+
+// appendJournal appends the changes between before and after to the journal.
+// Callers must hold the exclusive history lock.
+func appendJournal(st *journalState, before, after *ClipboardData) error {
+	recs := diffRecords(before, after)
+	if len(recs) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(st.journalPath, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var buf bytes.Buffer
+	if st.validLen == 0 {
+		// New, stale or entirely torn journal: start over for this generation
+		if err := encodeRecord(&buf, journalRecord{Op: opBegin, Generation: st.generation}, st.cipher); err != nil {
+			return err
+		}
+	}
+	for _, rec := range recs {
+		if err := encodeRecord(&buf, rec, st.cipher); err != nil {
+			return err
+		}
+	}
+	if st.fileLen != st.validLen {
+		// Drop a torn tail so new records follow the last valid one
+		if err := f.Truncate(st.validLen); err != nil {
+			return err
+		}
+	}
+	if _, err := f.Seek(st.validLen, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	st.validLen += int64(buf.Len())
+	st.fileLen = st.validLen
+	st.journalRecs += len(recs)
+	return nil
+}
+
+// needsCompaction reports whether the snapshot still has an old schema, the
+// journal has damaged records or superseded records make up more than
+// CompactRatio of everything stored for clipData.
+func (st *journalState) needsCompaction(clipData *ClipboardData) bool {
+	if st.version < SchemaVersion {
+		return true // persist the migrated layout
+	}
+	if st.damaged > 0 {
+		return true // rewrite the history without them
+	}
+	if st.journalRecs < minCompactRecords {
+		return false
+	}
+	total := st.snapshotRecs + st.journalRecs
+	live := len(clipData.History) + len(clipData.Pinned)
+	return float64(total-live)/float64(total) > CompactRatio
+}
+
+// compactJournal writes clipData as the next generation's snapshot and
+// starts a fresh journal for it. Callers must hold the exclusive history lock.
+func compactJournal(st *journalState, clipData *ClipboardData) error {
+	next := st.generation + 1
+	if err := writeSnapshot(st.snapshotPath, clipData, next, st.cipher); err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := encodeRecord(&buf, journalRecord{Op: opBegin, Generation: next}, st.cipher); err != nil {
+		return err
+	}
+	return writeFileAtomic(st.journalPath, buf.Bytes())
+}
+
+// clone returns a deep copy of the clipboard data.
+func (cd *ClipboardData) clone() *ClipboardData {
+	c := &ClipboardData{
+		History: append([]Entry(nil), cd.History...),
+		Pinned:  make(map[string]bool, len(cd.Pinned)),
+		Trash:   append([]TrashedEntry(nil), cd.Trash...),
+	}
+	for id, v := range cd.Pinned {
+		c.Pinned[id] = v
+	}
+	return c
+}
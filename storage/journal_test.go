@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// addAll adds entries with the given contents to s, oldest first.
+func addAll(t *testing.T, s Store, contents ...string) {
+	t.Helper()
+	for _, c := range contents {
+		if _, err := s.Add(Entry{Content: c}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// historyOf returns the contents of the history in s, most recent first.
+func historyOf(t *testing.T, s Store) []string {
+	t.Helper()
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var contents []string
+	for _, e := range entries {
+		contents = append(contents, e.Content)
+	}
+	return contents
+}
+
+// checkHistory fails unless the history in s holds want, most recent first.
+func checkHistory(t *testing.T, s Store, want ...string) {
+	t.Helper()
+	if got := historyOf(t, s); !slices.Equal(got, want) {
+		t.Errorf("history = %q, want %q", got, want)
+	}
+}
+
+// journalLines returns the lines of the journal in dir.
+func journalLines(t *testing.T, dir string) [][]byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, JournalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	return lines[:len(lines)-1] // the empty one after the last newline
+}
+
+// writeJournal replaces the journal in dir with lines.
+func writeJournal(t *testing.T, dir string, lines [][]byte) {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, JournalFileName), bytes.Join(lines, nil), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	dir := t.TempDir()
+	addAll(t, NewFileStore(dir), "a", "b", "c")
+	if _, err := os.Stat(filepath.Join(dir, HistoryFileName)); !os.IsNotExist(err) {
+		t.Fatalf("history file written without a compaction: %v", err)
+	}
+	// A fresh store sees the journaled changes
+	s := NewFileStore(dir)
+	checkHistory(t, s, "c", "b", "a")
+	if err := s.SetPinned(HashID("a"), true); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(HashID("b")); err != nil {
+		t.Fatal(err)
+	}
+	s = NewFileStore(dir)
+	checkHistory(t, s, "c", "a")
+	if cd, err := Snapshot(s); err != nil || !cd.IsPinned(HashID("a")) {
+		t.Errorf("pin not replayed: %v", err)
+	}
+}
+
+func TestJournalTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	addAll(t, s, "a", "b")
+	lines := journalLines(t, dir)
+	// The last write stopped halfway through its record
+	last := lines[len(lines)-1]
+	writeJournal(t, dir, append(lines[:len(lines)-1], last[:len(last)/2]))
+
+	checkHistory(t, s, "a")
+	report, err := s.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Error("fsck missed the torn tail")
+	}
+	// The next append replaces the torn record
+	addAll(t, s, "c")
+	checkHistory(t, s, "c", "a")
+	if report, err = s.Fsck(true); err != nil || !report.OK() {
+		t.Errorf("fsck after append: %+v, %v", report, err)
+	}
+}
+
+func TestJournalDamagedRecord(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	addAll(t, s, "a", "b", "c")
+	// Lines are begin, put a, put b, put c; flip a byte of put b
+	lines := journalLines(t, dir)
+	damaged := bytes.Clone(lines[2])
+	damaged[len(damaged)-3] ^= 1
+	lines[2] = damaged
+	writeJournal(t, dir, lines)
+
+	// Only the damaged record is lost, not the ones after it
+	checkHistory(t, s, "c", "a")
+	report, err := s.Fsck(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() {
+		t.Error("fsck missed the damaged record")
+	}
+	addAll(t, s, "d")
+	checkHistory(t, s, "d", "c", "a")
+	// The append compacts the damaged record away
+	for s.compacting.Load() {
+		time.Sleep(time.Millisecond)
+	}
+	checkHistory(t, NewFileStore(dir), "d", "c", "a")
+	if report, err = s.Fsck(true); err != nil || !report.OK() {
+		t.Errorf("fsck after compaction: %+v, %v", report, err)
+	}
+}
+
+func TestJournalStaleGeneration(t *testing.T) {
+	dir := t.TempDir()
+	s := NewFileStore(dir)
+	addAll(t, s, "a", "b", "c")
+	// A compaction wrote the next generation's snapshot, in which "a" has
+	// since been dropped, and crashed before replacing the journal
+	clipData, st, err := loadState(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clipData.Remove(clipData.IndexOf(HashID("a")))
+	if err := writeSnapshot(st.snapshotPath, clipData, st.generation+1, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Replaying the stale journal would bring "a" back
+	checkHistory(t, s, "c", "b")
+	addAll(t, s, "d")
+	checkHistory(t, s, "d", "c", "b")
+	lines := journalLines(t, dir)
+	begin, ok, err := decodeRecord(bytes.TrimSuffix(lines[0], []byte("\n")), nil)
+	if err != nil || !ok || begin.Op != opBegin || begin.Generation != st.generation+1 {
+		t.Errorf("journal starts with %+v (ok=%v, %v), want begin of generation %d", begin, ok, err, st.generation+1)
+	}
+}
+
+func TestConcurrentUpdates(t *testing.T) {
+	dir := t.TempDir()
+	addAll(t, NewFileStore(dir), "counter")
+	const writers, updates = 4, 25
+	// Separate stores take separate flocks, as separate processes do
+	stores := make([]*FileStore, writers)
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for w := range stores {
+		stores[w] = NewFileStore(dir)
+		wg.Add(1)
+		go func(s *FileStore) {
+			defer wg.Done()
+			for i := range updates {
+				err := s.Update(func(cd *ClipboardData) error {
+					cd.MarkUsed(cd.IndexOf(HashID("counter")))
+					cd.Put(Entry{Content: fmt.Sprintf("writer %d update %d", w, i)}, time.Now())
+					return nil
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(stores[w])
+	}
+	wg.Wait()
+	// Updates start background compactions too
+	for _, s := range stores {
+		for s.compacting.Load() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	cd, err := Snapshot(NewFileStore(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cd.History) != 1+writers*updates {
+		t.Errorf("history has %d entries, want %d", len(cd.History), 1+writers*updates)
+	}
+	if i := cd.IndexOf(HashID("counter")); i < 0 {
+		t.Error("counter entry lost")
+	} else if got := cd.History[i].UseCount; got != writers*updates {
+		t.Errorf("counter used %d times, want %d", got, writers*updates)
+	}
+}
@@ -0,0 +1,81 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// LockFileName is the name of the advisory lock file guarding the history.
+const LockFileName = "clip_history.lock"
+
+// LockTimeout is how long a transaction waits for the history lock.
+var LockTimeout = 5 * time.Second
+
+// lockPollInterval is how often a blocked transaction retries the lock.
+const lockPollInterval = 10 * time.Millisecond
+
+// ErrLockTimeout is returned (wrapped in a *LockError) when another process
+// holds the history lock for longer than LockTimeout.
+var ErrLockTimeout = errors.New("timed out waiting for history lock")
+
+// LockError describes a failure to acquire or release the history lock.
+type LockError struct {
+	Path string
+	Err  error
+}
+
+func (e *LockError) Error() string {
+	return fmt.Sprintf("lock %s: %v", e.Path, e.Err)
+}
+
+func (e *LockError) Unwrap() error {
+	return e.Err
+}
+
+// fileLock is a held flock(2) on the lock file.
+type fileLock struct {
+	f *os.File
+}
+
+// acquireLock takes a shared or exclusive flock on the lock file in dir,
+// polling until timeout elapses.
+func acquireLock(dir string, exclusive bool, timeout time.Duration) (*fileLock, error) {
+	p := filepath.Join(dir, LockFileName)
+	f, err := os.OpenFile(p, os.O_CREATE|os.O_RDWR, 0o600)
+	if err != nil {
+		return nil, &LockError{Path: p, Err: err}
+	}
+	how := syscall.LOCK_SH
+	if exclusive {
+		how = syscall.LOCK_EX
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		err := syscall.Flock(int(f.Fd()), how|syscall.LOCK_NB)
+		if err == nil {
+			return &fileLock{f: f}, nil
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) && !errors.Is(err, syscall.EINTR) {
+			f.Close()
+			return nil, &LockError{Path: p, Err: err}
+		}
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, &LockError{Path: p, Err: ErrLockTimeout}
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// release drops the lock and closes the lock file.
+func (l *fileLock) release() error {
+	defer l.f.Close()
+	if err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN); err != nil {
+		return &LockError{Path: l.f.Name(), Err: err}
+	}
+	return nil
+}
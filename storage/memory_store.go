@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+	"sync"
+)
+
+// MemoryStore keeps history in process memory only. It is meant for tests
+// and for ephemeral sessions that must not touch disk.
+type MemoryStore struct {
+	ops
+	mu   sync.RWMutex
+	data *ClipboardData
+}
+
+var _ Store = (*MemoryStore)(nil)
+
+// NewMemoryStore returns an empty in-memory store.
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{data: newClipboardData()}
+	s.ops = ops{tx: s}
+	return s
+}
+
+// Update runs fn against a copy of the history and keeps the result if fn
+// succeeds.
+func (s *MemoryStore) Update(fn func(*ClipboardData) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	clipData := s.data.clone()
+	if err := fn(clipData); err != nil {
+		if errors.Is(err, ErrNoChange) {
+			return nil
+		}
+		return err
+	}
+	trimHistory(clipData)
+	s.data = clipData
+	return nil
+}
+
+// View runs fn against a copy of the history.
+func (s *MemoryStore) View(fn func(*ClipboardData) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(s.data.clone())
+}
+
+// Content returns the content of e; the memory store keeps everything inline.
+func (s *MemoryStore) Content(e Entry) (string, error) {
+	return e.Content, nil
+}
@@ -0,0 +1,127 @@
+package storage
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DefaultProfile is the profile whose history lives directly in BaseDir(),
+// where clipcli kept it before profiles existed.
+const DefaultProfile = "default"
+
+// ProfilesDirName is the BaseDir() subdirectory holding the other profiles.
+const ProfilesDirName = "profiles"
+
+// ActiveProfileFileName names the file in BaseDir() recording the profile
+// selected with `clipcli profile use`.
+const ActiveProfileFileName = "active_profile"
+
+// Profile is the profile DataDir() resolves to (configurable).
+var Profile = DefaultProfile
+
+// ValidateProfile checks that name can be used as a profile name: letters,
+// digits, '.', '_' and '-', not starting with a dot.
+func ValidateProfile(name string) error {
+	if name == "" || strings.HasPrefix(name, ".") {
+		return fmt.Errorf("invalid profile name %q", name)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		case r == '.', r == '_', r == '-':
+		default:
+			return fmt.Errorf("invalid profile name %q", name)
+		}
+	}
+	return nil
+}
+
+// ProfileDir returns the data directory of the named profile without
+// creating it.
+func ProfileDir(name string) (string, error) {
+	if err := ValidateProfile(name); err != nil {
+		return "", err
+	}
+	base, err := BaseDir()
+	if err != nil {
+		return "", err
+	}
+	if name == DefaultProfile {
+		return base, nil
+	}
+	return filepath.Join(base, ProfilesDirName, name), nil
+}
+
+// DataDir returns the data directory of the current Profile, creating it if
+// necessary.
+func DataDir() (string, error) {
+	dir, err := ProfileDir(Profile)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// Profiles returns the names of every profile, the default one first.
+func Profiles() ([]string, error) {
+	base, err := BaseDir()
+	if err != nil {
+		return nil, err
+	}
+	names, err := os.ReadDir(filepath.Join(base, ProfilesDirName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var profiles []string
+	for _, de := range names {
+		if de.IsDir() && ValidateProfile(de.Name()) == nil && de.Name() != DefaultProfile {
+			profiles = append(profiles, de.Name())
+		}
+	}
+	sort.Strings(profiles)
+	return append([]string{DefaultProfile}, profiles...), nil
+}
+
+// ActiveProfile returns the profile selected with SetActiveProfile, or
+// DefaultProfile if none was.
+func ActiveProfile() (string, error) {
+	base, err := BaseDir()
+	if err != nil {
+		return "", err
+	}
+	data, err := os.ReadFile(filepath.Join(base, ActiveProfileFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return DefaultProfile, nil
+		}
+		return "", err
+	}
+	name := strings.TrimSpace(string(data))
+	if err := ValidateProfile(name); err != nil {
+		return "", fmt.Errorf("%s: %w", ActiveProfileFileName, err)
+	}
+	return name, nil
+}
+
+// SetActiveProfile makes name the active profile, creating its data
+// directory. A running daemon switches over to it.
+func SetActiveProfile(name string) error {
+	dir, err := ProfileDir(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	base, err := BaseDir()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(base, ActiveProfileFileName), []byte(name+"\n"))
+}
@@ -0,0 +1,106 @@
+package storage
+
+import "time"
+
+// RetentionPolicy limits how long unpinned entries are kept after they were
+// last copied. A zero duration keeps entries forever.
+type RetentionPolicy struct {
+	MaxAge time.Duration            // default for every content type
+	ByType map[string]time.Duration // overrides keyed by content type
+	// Sensitive shortens the age of entries flagged as secrets. It applies
+	// to pinned secrets too, so a pin cannot keep one around.
+	Sensitive time.Duration
+}
+
+// Retention is the policy applied whenever history is written
+// (configurable).
+var Retention RetentionPolicy
+
+// Enabled reports whether the policy can expire anything.
+func (p RetentionPolicy) Enabled() bool {
+	if p.MaxAge > 0 || p.Sensitive > 0 {
+		return true
+	}
+	for _, d := range p.ByType {
+		if d > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// MaxAgeFor returns the maximum age of entries of the given content type.
+func (p RetentionPolicy) MaxAgeFor(typ string) time.Duration {
+	if d, ok := p.ByType[typ]; ok {
+		return d
+	}
+	return p.MaxAge
+}
+
+// Expired reports whether e has outlived the policy at now.
+func (p RetentionPolicy) Expired(e Entry, now time.Time) bool {
+	maxAge := p.MaxAgeFor(e.Type)
+	if e.Sensitive() && p.Sensitive > 0 && (maxAge <= 0 || p.Sensitive < maxAge) {
+		maxAge = p.Sensitive
+	}
+	if maxAge <= 0 {
+		return false
+	}
+	seen := e.LastSeen
+	if seen.IsZero() {
+		seen = e.FirstSeen
+	}
+	return !seen.IsZero() && now.Sub(seen) > maxAge
+}
+
+// Expired returns the unpinned entries that have outlived p at now, and the
+// pinned secrets that have outlived p.Sensitive.
+func (cd *ClipboardData) Expired(p RetentionPolicy, now time.Time) []Entry {
+	var expired []Entry
+	for _, e := range cd.History {
+		q := p
+		if cd.Pinned[e.ID] {
+			if !e.Sensitive() {
+				continue
+			}
+			q = RetentionPolicy{Sensitive: p.Sensitive}
+		}
+		if q.Expired(e, now) {
+			expired = append(expired, e)
+		}
+	}
+	return expired
+}
+
+// Expire removes the entries Expired returns and returns them.
+func (cd *ClipboardData) Expire(p RetentionPolicy, now time.Time) []Entry {
+	expired := cd.Expired(p, now)
+	for _, e := range expired {
+		if i := cd.IndexOf(e.ID); i >= 0 {
+			cd.Remove(i)
+		}
+	}
+	return expired
+}
+
+// Expire applies the Retention policy to the history in s and returns the
+// entries it removed; expired secrets are also dropped from the trash. With
+// dryRun set nothing is removed and the entries that would be are returned.
+func Expire(s Store, now time.Time, dryRun bool) ([]Entry, error) {
+	var expired []Entry
+	if dryRun {
+		err := s.View(func(cd *ClipboardData) error {
+			expired = cd.Expired(Retention, now)
+			return nil
+		})
+		return expired, err
+	}
+	err := s.Update(func(cd *ClipboardData) error {
+		expired = cd.Expire(Retention, now)
+		if purged := cd.expireTrash(Retention, now); len(expired) == 0 && !purged {
+			return ErrNoChange
+		}
+		return nil
+	})
+	return expired, err
+}
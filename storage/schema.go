@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SchemaVersion is the layout of the history file written by this build.
+// Files carry it in their "version" field; files without one predate
+// versioning and are recognised by their shape.
+const SchemaVersion = 3
+
+// ErrSchemaTooNew is returned for a history file written by a newer clipcli.
+// Such files are never rewritten, so an older binary cannot damage them.
+var ErrSchemaTooNew = errors.New("history file was written by a newer clipcli")
+
+// historyDoc is the raw history file, decoded just far enough to migrate it.
+type historyDoc struct {
+	Version    int               `json:"version"`
+	History    []json.RawMessage `json:"history"`
+	Pinned     map[string]bool   `json:"pinned"`
+	Trash      []TrashedEntry    `json:"trash"`
+	Generation int64             `json:"generation"`
+}
+
+// migration upgrades a history document by one schema version.
+type migration struct {
+	desc  string
+	apply func(doc *historyDoc, migratedAt time.Time) error
+}
+
+// migrations[v] turns schema version v into v+1. Append new steps here and
+// bump SchemaVersion; never change existing ones.
+var migrations = []migration{
+	{"plain string history to entry records", migrateStringEntries},
+	{"content-hash IDs and ID-keyed pins", migrateHashIDs},
+	{"trash section", migrateNothing},
+}
+
+// Schema versions of files from before the "version" field existed.
+const (
+	schemaStrings = 0 // bare array of strings, or strings next to content-keyed pins
+	schemaEntries = 1 // entry records with sequential IDs and content-keyed pins
+)
+
+// parseHistoryDoc decodes a history file and works out its schema version.
+func parseHistoryDoc(data []byte) (*historyDoc, error) {
+	data = bytes.TrimSpace(data)
+	doc := &historyDoc{}
+	if len(data) > 0 && data[0] == '[' {
+		// Oldest format: just an array of strings
+		if err := json.Unmarshal(data, &doc.History); err != nil {
+			return nil, err
+		}
+		doc.Version = schemaStrings
+		return doc, nil
+	}
+	var versioned struct {
+		Version *int `json:"version"`
+	}
+	if err := json.Unmarshal(data, &versioned); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, doc); err != nil {
+		return nil, err
+	}
+	if versioned.Version == nil {
+		doc.Version = schemaEntries
+		for _, item := range doc.History {
+			if item = bytes.TrimSpace(item); len(item) > 0 && item[0] == '"' {
+				doc.Version = schemaStrings
+				break
+			}
+		}
+	}
+	return doc, nil
+}
+
+// migrate runs every migration needed to bring doc to SchemaVersion.
+func migrate(doc *historyDoc, migratedAt time.Time) error {
+	if doc.Version > SchemaVersion {
+		return fmt.Errorf("schema version %d, this build supports up to %d: %w", doc.Version, SchemaVersion, ErrSchemaTooNew)
+	}
+	for doc.Version < SchemaVersion {
+		m := migrations[doc.Version]
+		if err := m.apply(doc, migratedAt); err != nil {
+			return fmt.Errorf("migrate history (%s): %w", m.desc, err)
+		}
+		doc.Version++
+	}
+	return nil
+}
+
+// migrateStringEntries turns plain string items into entry records. Legacy
+// entries carry no timestamps, so migratedAt stands in for them.
+func migrateStringEntries(doc *historyDoc, migratedAt time.Time) error {
+	for i, item := range doc.History {
+		var text string
+		if err := json.Unmarshal(item, &text); err != nil {
+			return err
+		}
+		raw, err := json.Marshal(NewEntry(text, "", migratedAt))
+		if err != nil {
+			return err
+		}
+		doc.History[i] = raw
+	}
+	return nil
+}
+
+// migrateHashIDs derives every entry ID from its content and rewrites pins
+// keyed by content or by an old ID to the new ID.
+func migrateHashIDs(doc *historyDoc, migratedAt time.Time) error {
+	pinned := make(map[string]bool, len(doc.Pinned))
+	for i, item := range doc.History {
+		var e Entry
+		if err := json.Unmarshal(item, &e); err != nil {
+			return err
+		}
+		oldID := e.ID
+		if e.Blob == "" {
+			e.ID = HashID(e.Content)
+		}
+		if doc.Pinned[oldID] || doc.Pinned[e.Content] {
+			pinned[e.ID] = true
+		}
+		raw, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		doc.History[i] = raw
+	}
+	doc.Pinned = pinned
+	return nil
+}
+
+// migrateNothing marks a version that only added optional data, so files
+// of that version are refused by older builds that would drop it.
+func migrateNothing(doc *historyDoc, migratedAt time.Time) error {
+	return nil
+}
+
+// decodeClipboardData turns a current-version document into clipboard data.
+func decodeClipboardData(doc *historyDoc) (*ClipboardData, error) {
+	clipData := newClipboardData()
+	for _, item := range doc.History {
+		var e Entry
+		if err := json.Unmarshal(item, &e); err != nil {
+			return nil, err
+		}
+		clipData.History = append(clipData.History, e)
+	}
+	for _, e := range clipData.History {
+		if doc.Pinned[e.ID] {
+			clipData.Pinned[e.ID] = true
+		}
+	}
+	clipData.Trash = doc.Trash
+	return clipData, nil
+}
+
+// backupBeforeMigration keeps a copy of the history file as it was before
+// it is first migrated away from version. The copy is written once and
+// holds the file's bytes unchanged, encrypted or not.
+func backupBeforeMigration(p string, version int, raw []byte) error {
+	backup := filepath.Join(filepath.Dir(p), fmt.Sprintf("%s.v%d.bak", filepath.Base(p), version))
+	if _, err := os.Stat(backup); err == nil {
+		return nil
+	}
+	return writeFileAtomic(backup, raw)
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Kinds of secret recorded in Entry.Secret.
+const (
+	SecretPassword   = "password" // marked by the password manager that copied it
+	SecretPrivateKey = "private key"
+	SecretAWS        = "AWS key"
+	SecretGCP        = "GCP key"
+	SecretGitHub     = "GitHub token"
+	SecretJWT        = "JWT"
+	SecretCard       = "card number"
+)
+
+// secretMask replaces the content of sensitive entries in lists.
+const secretMask = "••••••••"
+
+// secretDetectors recognise secrets by their well-known formats. A match
+// only counts if valid, when set, accepts it.
+var secretDetectors = []struct {
+	kind  string
+	re    *regexp.Regexp
+	valid func(match string) bool
+}{
+	{SecretPrivateKey, regexp.MustCompile(`-----BEGIN (?:[A-Z0-9]+ )*PRIVATE KEY(?: BLOCK)?-----`), nil},
+	// Access key IDs of users and of temporary credentials, and secret keys
+	// as written in credentials files and environments
+	{SecretAWS, regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`), nil},
+	{SecretAWS, regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[=:]\s*["']?[A-Za-z0-9/+]{40}\b`), nil},
+	// Service account keys, API keys, OAuth client secrets and access tokens
+	{SecretGCP, regexp.MustCompile(`"type"\s*:\s*"service_account"`), nil},
+	{SecretGCP, regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}`), nil},
+	{SecretGCP, regexp.MustCompile(`\bGOCSPX-[0-9A-Za-z_-]{28}`), nil},
+	{SecretGCP, regexp.MustCompile(`\bya29\.[0-9A-Za-z_-]{20,}`), nil},
+	{SecretGitHub, regexp.MustCompile(`\bgh[pousr]_[A-Za-z0-9]{36,}`), nil},
+	{SecretGitHub, regexp.MustCompile(`\bgithub_pat_[A-Za-z0-9_]{22,}`), nil},
+	{SecretJWT, regexp.MustCompile(`\beyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`), validJWT},
+	{SecretCard, regexp.MustCompile(`\b\d(?:[ -]?\d){12,18}\b`), validCard},
+}
+
+// DetectSecret returns the kind of secret text contains, or "" if the
+// detectors find none.
+func DetectSecret(text string) string {
+	for _, d := range secretDetectors {
+		for _, m := range d.re.FindAllString(text, -1) {
+			if d.valid == nil || d.valid(m) {
+				return d.kind
+			}
+		}
+	}
+	return ""
+}
+
+// validJWT reports whether the header of a token that looks like a JWT
+// decodes to JSON naming a signing algorithm.
+func validJWT(token string) bool {
+	header, _, _ := strings.Cut(token, ".")
+	data, err := base64.RawURLEncoding.DecodeString(header)
+	if err != nil {
+		return false
+	}
+	var fields struct {
+		Alg string `json:"alg"`
+	}
+	return json.Unmarshal(data, &fields) == nil && fields.Alg != ""
+}
+
+// validCard reports whether digits, which may be grouped by spaces or
+// dashes, form a card number: one with a known issuer prefix and a valid
+// Luhn check digit.
+func validCard(number string) bool {
+	digits := strings.NewReplacer(" ", "", "-", "").Replace(number)
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+	// Visa, Mastercard, American Express, Diners Club, JCB, Discover and
+	// UnionPay; other numbers pass the Luhn check too often
+	switch digits[:2] {
+	case "40", "41", "42", "43", "44", "45", "46", "47", "48", "49",
+		"51", "52", "53", "54", "55", "22", "23", "24", "25", "26", "27",
+		"34", "37", "30", "36", "38", "35", "60", "62", "64", "65":
+	default:
+		return false
+	}
+	sum := 0
+	for i := range len(digits) {
+		d := int(digits[len(digits)-1-i] - '0')
+		if i%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
+
+// Sensitive reports whether the entry was flagged as a secret.
+func (e Entry) Sensitive() bool {
+	return e.Secret != ""
+}
+
+// Mask returns what is shown instead of the content of a sensitive entry,
+// such as "•••••••• password".
+func (e Entry) Mask() string {
+	return secretMask + " " + e.Secret
+}
+
+// dropSecrets removes sensitive entries, and their pins, from the history
+// and the trash.
+func (cd *ClipboardData) dropSecrets() {
+	for i := len(cd.History) - 1; i >= 0; i-- {
+		if cd.History[i].Sensitive() {
+			cd.Remove(i)
+		}
+	}
+	cd.Trash = slices.DeleteFunc(cd.Trash, func(t TrashedEntry) bool { return t.Sensitive() })
+}
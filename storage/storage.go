@@ -3,9 +3,11 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 const (
@@ -18,29 +20,45 @@ const (
 // MaxHistory is the maximum number of history entries to keep (configurable).
 var MaxHistory = 500
 
-// ClipboardData represents the complete clipboard storage with history and pinned items.
+// ClipboardData represents the complete clipboard storage with history, pinned items and trash.
 type ClipboardData struct {
-	History []string          `json:"history"`
-	Pinned  map[string]bool   `json:"pinned"` // Map of content hash to pinned status
+	History []Entry         `json:"history"`
+	Pinned  map[string]bool `json:"pinned"`          // Map of entry ID to pinned status
+	Trash   []TrashedEntry  `json:"trash,omitempty"` // Deleted entries, most recent first
 }
 
-// DataDir returns the data directory for clipcli, creating it if necessary.
-func DataDir() (string, error) {
-	xdg := os.Getenv("XDG_DATA_HOME")
-	if xdg == "" {
-		home := os.Getenv("HOME")
-		if home == "" {
-			return "", fmt.Errorf("no HOME or XDG_DATA_HOME set")
-		}
-		xdg = filepath.Join(home, ".local", "share")
+// newClipboardData returns an empty ClipboardData.
+func newClipboardData() *ClipboardData {
+	return &ClipboardData{History: []Entry{}, Pinned: make(map[string]bool)}
+}
+
+// BaseDir returns the top-level data directory for clipcli, creating it if
+// necessary. It holds the log, the default profile's history and the other
+// profiles.
+func BaseDir() (string, error) {
+	xdg, err := dataHome()
+	if err != nil {
+		return "", err
 	}
 	dir := filepath.Join(xdg, "clipcli")
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
 		return "", err
 	}
 	return dir, nil
 }
 
+// dataHome returns the XDG data directory shared by all applications.
+func dataHome() (string, error) {
+	if xdg := os.Getenv("XDG_DATA_HOME"); xdg != "" {
+		return xdg, nil
+	}
+	home := os.Getenv("HOME")
+	if home == "" {
+		return "", fmt.Errorf("no HOME or XDG_DATA_HOME set")
+	}
+	return filepath.Join(home, ".local", "share"), nil
+}
+
 // HistoryFilePath returns the path to the history file.
 func HistoryFilePath() (string, error) {
 	dir, err := DataDir()
@@ -50,136 +68,145 @@ func HistoryFilePath() (string, error) {
 	return filepath.Join(dir, HistoryFileName), nil
 }
 
-// LogFilePath returns the path to the log file.
+// LogFilePath returns the path to the log file, shared by all profiles.
 func LogFilePath() (string, error) {
-	dir, err := DataDir()
+	dir, err := BaseDir()
 	if err != nil {
 		return "", err
 	}
 	return filepath.Join(dir, LogFileName), nil
 }
 
-// LoadClipboardData loads the complete clipboard data (history + pinned) from disk.
-func LoadClipboardData() (*ClipboardData, error) {
-	p, err := HistoryFilePath()
-	if err != nil {
-		return nil, err
-	}
-	data, err := os.ReadFile(p)
+// readSnapshot reads the history file along with the journal generation it
+// was compacted at and the schema version it was written with, decrypting it
+// with c if it is encrypted. Files from older versions are migrated in
+// memory after a backup copy has been taken; the migrated layout reaches
+// disk with the next compaction. A damaged file is reported as a
+// *CorruptError.
+func readSnapshot(p string, c *Cipher) (*ClipboardData, int64, int, error) {
+	raw, err := os.ReadFile(p)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return &ClipboardData{History: []string{}, Pinned: make(map[string]bool)}, nil
+			return newClipboardData(), 0, SchemaVersion, nil
 		}
-		return nil, err
+		return nil, 0, 0, err
 	}
+	return decodeSnapshot(p, raw, c)
+}
 
-	// Try to parse as new format first
-	var clipData ClipboardData
-	if err := json.Unmarshal(data, &clipData); err == nil && clipData.History != nil {
-		if clipData.Pinned == nil {
-			clipData.Pinned = make(map[string]bool)
+// decodeSnapshot decodes the raw contents of the history file p.
+func decodeSnapshot(p string, raw []byte, c *Cipher) (*ClipboardData, int64, int, error) {
+	data, err := openFile(c, raw)
+	if err != nil {
+		if c != nil && errors.Is(err, ErrWrongKey) {
+			// The key was verified, so the ciphertext itself is damaged
+			return nil, 0, 0, &CorruptError{Path: p, Err: err}
 		}
-		return &clipData, nil
-	}
-
-	// Fallback: try to parse as old format (just array of strings)
-	var hist []string
-	if err := json.Unmarshal(data, &hist); err != nil {
-		return nil, err
+		return nil, 0, 0, err
 	}
-	return &ClipboardData{History: hist, Pinned: make(map[string]bool)}, nil
-}
-
-// SaveClipboardData writes the complete clipboard data to disk atomically.
-func SaveClipboardData(clipData *ClipboardData) error {
-	p, err := HistoryFilePath()
+	doc, err := parseHistoryDoc(data)
 	if err != nil {
-		return err
+		return nil, 0, 0, &CorruptError{Path: p, Err: err}
 	}
-	// Preserve pinned items when trimming - only trim unpinned items
-	if len(clipData.History) > MaxHistory {
-		var pinned, unpinned []string
-		for _, item := range clipData.History {
-			if clipData.Pinned[item] {
-				pinned = append(pinned, item)
-			} else {
-				unpinned = append(unpinned, item)
-			}
-		}
-		// Keep all pinned + as many unpinned as will fit
-		maxUnpinned := MaxHistory - len(pinned)
-		if maxUnpinned < 0 {
-			maxUnpinned = 0
+	version := doc.Version
+	if version < SchemaVersion {
+		if err := backupBeforeMigration(p, version, raw); err != nil {
+			return nil, 0, 0, fmt.Errorf("back up history before migration: %w", err)
 		}
-		if len(unpinned) > maxUnpinned {
-			unpinned = unpinned[:maxUnpinned]
+	}
+	// Legacy entries carry no timestamps; the file's mtime is the best guess.
+	migratedAt := time.Now()
+	if fi, err := os.Stat(p); err == nil {
+		migratedAt = fi.ModTime()
+	}
+	if err := migrate(doc, migratedAt); err != nil {
+		if errors.Is(err, ErrSchemaTooNew) {
+			return nil, 0, 0, fmt.Errorf("%s: %w", p, err)
 		}
-		clipData.History = append(pinned, unpinned...)
+		return nil, 0, 0, &CorruptError{Path: p, Err: err}
 	}
-	dir := filepath.Dir(p)
-	tmp := filepath.Join(dir, fmt.Sprintf(".%s.tmp", HistoryFileName))
-	data, err := json.MarshalIndent(clipData, "", "  ")
+	clipData, err := decodeClipboardData(doc)
 	if err != nil {
-		return err
-	}
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
-		return err
+		return nil, 0, 0, &CorruptError{Path: p, Err: err}
 	}
-	return os.Rename(tmp, p)
+	return clipData, doc.Generation, version, nil
 }
 
-// LoadHistory loads only the history (backward compatible).
-func LoadHistory() ([]string, error) {
-	clipData, err := LoadClipboardData()
-	if err != nil {
-		return nil, err
-	}
-	return clipData.History, nil
+// trimHistory enforces Retention, MaxEntryBytes, MaxHistory and
+// MaxTotalBytes, and purges old trash. Pinned items are kept, except for
+// secrets past their retention; the oldest unpinned items are dropped first.
+func trimHistory(clipData *ClipboardData) {
+	now := time.Now()
+	clipData.Expire(Retention, now)
+	purgeTrash(clipData, now)
+	limitEntrySizes(clipData)
+	limitCount(clipData)
+	limitTotalSize(clipData)
 }
 
-// SaveHistory saves only the history (backward compatible).
-func SaveHistory(hist []string) error {
-	clipData, err := LoadClipboardData()
-	if err != nil {
-		// If loading fails, create new data
-		clipData = &ClipboardData{Pinned: make(map[string]bool)}
+// limitCount enforces MaxHistory by dropping the oldest unpinned entries,
+// leaving the rest in order.
+func limitCount(clipData *ClipboardData) {
+	excess := len(clipData.History) - MaxHistory
+	for i := len(clipData.History) - 1; i >= 0 && excess > 0; i-- {
+		if !clipData.Pinned[clipData.History[i].ID] {
+			clipData.Remove(i)
+			excess--
+		}
 	}
-	clipData.History = hist
-	return SaveClipboardData(clipData)
 }
 
-// IsPinned checks if an item is pinned.
-func IsPinned(text string) bool {
-	clipData, err := LoadClipboardData()
+// writeSnapshot writes the complete clipboard data to the history file
+// atomically, tagged with the journal generation it supersedes. The file is
+// encrypted when c is set.
+func writeSnapshot(p string, clipData *ClipboardData, generation int64, c *Cipher) error {
+	data, err := encodeSnapshot(clipData, generation)
 	if err != nil {
-		return false
+		return err
+	}
+	if c != nil {
+		data = sealFile(c, data)
 	}
-	return clipData.Pinned[text]
+	return writeFileAtomic(p, data)
 }
 
-// TogglePin toggles the pinned status of an item.
-func TogglePin(text string) (bool, error) {
-	clipData, err := LoadClipboardData()
+// encodeSnapshot renders clipboard data in the current history file layout.
+func encodeSnapshot(clipData *ClipboardData, generation int64) ([]byte, error) {
+	// The generation goes first so a truncated file still carries it
+	doc := struct {
+		Version    int   `json:"version"`
+		Generation int64 `json:"generation,omitempty"`
+		*ClipboardData
+	}{SchemaVersion, generation, clipData}
+	return json.MarshalIndent(doc, "", "  ")
+}
+
+// writeFileAtomic replaces p with data via a synced temporary file.
+func writeFileAtomic(p string, data []byte) error {
+	tmp := filepath.Join(filepath.Dir(p), fmt.Sprintf(".%s.tmp", filepath.Base(p)))
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
 	if err != nil {
-		return false, err
+		return err
 	}
-	if clipData.Pinned == nil {
-		clipData.Pinned = make(map[string]bool)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
 	}
-	newStatus := !clipData.Pinned[text]
-	if newStatus {
-		clipData.Pinned[text] = true
-	} else {
-		delete(clipData.Pinned, text)
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
 	}
-	return newStatus, SaveClipboardData(clipData)
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
 }
 
 // GetPinnedItems returns only the pinned items from history.
-func GetPinnedItems(clipData *ClipboardData) []string {
-	var pinned []string
+func GetPinnedItems(clipData *ClipboardData) []Entry {
+	var pinned []Entry
 	for _, item := range clipData.History {
-		if clipData.Pinned[item] {
+		if clipData.Pinned[item.ID] {
 			pinned = append(pinned, item)
 		}
 	}
@@ -187,10 +214,10 @@ func GetPinnedItems(clipData *ClipboardData) []string {
 }
 
 // GetUnpinnedItems returns only the unpinned items from history.
-func GetUnpinnedItems(clipData *ClipboardData) []string {
-	var unpinned []string
+func GetUnpinnedItems(clipData *ClipboardData) []Entry {
+	var unpinned []Entry
 	for _, item := range clipData.History {
-		if !clipData.Pinned[item] {
+		if !clipData.Pinned[item.ID] {
 			unpinned = append(unpinned, item)
 		}
 	}
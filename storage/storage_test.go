@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLimitCountKeepsOrder(t *testing.T) {
+	old := MaxHistory
+	MaxHistory = 3
+	t.Cleanup(func() { MaxHistory = old })
+
+	start := time.Now().Add(-time.Hour)
+	cd := newClipboardData()
+	for i, c := range []string{"pinned", "a", "b", "c"} {
+		cd.Put(Entry{Content: c}, start.Add(time.Duration(i)*time.Minute))
+	}
+	cd.Pinned[HashID("pinned")] = true
+	limitCount(cd)
+	contents := func() []string {
+		var got []string
+		for _, e := range cd.History {
+			got = append(got, e.Content)
+		}
+		return got
+	}
+	// The oldest unpinned entry goes; the pinned one keeps its place
+	if got, want := contents(), []string{"c", "b", "pinned"}; !slices.Equal(got, want) {
+		t.Fatalf("history after limitCount = %q, want %q", got, want)
+	}
+
+	// Restored entries go back where their last use puts them
+	cd.Discard(cd.IndexOf(HashID("b")), time.Now())
+	cd.Restore(0)
+	if got, want := contents(), []string{"c", "b", "pinned"}; !slices.Equal(got, want) {
+		t.Errorf("history after restore = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Storage backends selectable in the config file.
+const (
+	BackendJSON   = "json"
+	BackendMemory = "memory"
+)
+
+// ErrNoChange can be returned from an Update callback to end the
+// transaction successfully without writing anything.
+var ErrNoChange = errors.New("no change")
+
+// Store persists clipboard history and pins.
+type Store interface {
+	// List returns every entry, most recent first.
+	List() ([]Entry, error)
+	// Get returns the entry a reference (index or ID prefix) points at.
+	Get(ref string) (Entry, error)
+	// Content returns the full content of e, loading it from its blob if
+	// the entry was too large to keep inline.
+	Content(e Entry) (string, error)
+	// Add records e as the most recent entry, moving an existing entry with
+	// the same content to the top instead of duplicating it.
+	Add(e Entry) (Entry, error)
+	// Delete moves the entries with the given IDs and their pins to the trash.
+	Delete(ids ...string) error
+	// SetPinned pins or unpins the entry with the given ID.
+	SetPinned(id string, pinned bool) error
+	// TogglePin flips the pinned status of an entry and returns the new one.
+	TogglePin(id string) (bool, error)
+	// Query returns the entries matching q, most recent first.
+	Query(q Query) ([]Entry, error)
+	// Update runs fn as a transaction; if fn returns an error nothing is
+	// written. ErrNoChange ends the transaction without error.
+	Update(fn func(*ClipboardData) error) error
+	// View runs fn against a consistent snapshot; changes are discarded.
+	View(fn func(*ClipboardData) error) error
+}
+
+// Query filters history entries. Zero fields match everything.
+type Query struct {
+	Text       string    // case-insensitive substring of the content (preview for blob entries)
+	Type       string    // content type, e.g. TypeURL
+	PinnedOnly bool      // only pinned entries
+	Since      time.Time // only entries last seen at or after this time
+	Limit      int       // maximum number of results
+}
+
+// Match reports whether the entry satisfies the query.
+func (q Query) Match(e Entry, pinned bool) bool {
+	if q.PinnedOnly && !pinned {
+		return false
+	}
+	if q.Type != "" && e.Type != q.Type {
+		return false
+	}
+	if !q.Since.IsZero() && e.LastSeen.Before(q.Since) {
+		return false
+	}
+	if q.Text != "" && !strings.Contains(strings.ToLower(e.Content), strings.ToLower(q.Text)) {
+		return false
+	}
+	return true
+}
+
+// Snapshot returns a copy of the complete clipboard data held by s.
+func Snapshot(s Store) (*ClipboardData, error) {
+	var clipData *ClipboardData
+	err := s.View(func(cd *ClipboardData) error {
+		clipData = cd
+		return nil
+	})
+	return clipData, err
+}
+
+// Open returns the store for the named backend. The JSON backend keeps its
+// files in DataDir().
+func Open(backend string) (Store, error) {
+	switch backend {
+	case "", BackendJSON:
+		dir, err := DataDir()
+		if err != nil {
+			return nil, err
+		}
+		return NewFileStore(dir), nil
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// txStore is the transaction primitive every backend provides.
+type txStore interface {
+	Update(fn func(*ClipboardData) error) error
+	View(fn func(*ClipboardData) error) error
+}
+
+// ops implements the convenience methods of Store on top of a backend's
+// transactions.
+type ops struct {
+	tx txStore
+}
+
+func (o ops) List() ([]Entry, error) {
+	var hist []Entry
+	err := o.tx.View(func(cd *ClipboardData) error {
+		hist = cd.History
+		return nil
+	})
+	return hist, err
+}
+
+func (o ops) Get(ref string) (Entry, error) {
+	var e Entry
+	err := o.tx.View(func(cd *ClipboardData) error {
+		i, err := cd.Resolve(ref)
+		if err != nil {
+			return err
+		}
+		e = cd.History[i]
+		return nil
+	})
+	return e, err
+}
+
+func (o ops) Add(e Entry) (Entry, error) {
+	var added Entry
+	err := o.tx.Update(func(cd *ClipboardData) error {
+		added = cd.Put(e, time.Now())
+		return nil
+	})
+	return added, err
+}
+
+func (o ops) Delete(ids ...string) error {
+	return o.tx.Update(func(cd *ClipboardData) error {
+		for _, id := range ids {
+			if i := cd.IndexOf(id); i >= 0 {
+				cd.Discard(i, time.Now())
+			}
+		}
+		return nil
+	})
+}
+
+func (o ops) SetPinned(id string, pinned bool) error {
+	return o.tx.Update(func(cd *ClipboardData) error {
+		if cd.IndexOf(id) < 0 {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		if pinned {
+			cd.Pinned[id] = true
+		} else {
+			delete(cd.Pinned, id)
+		}
+		return nil
+	})
+}
+
+func (o ops) TogglePin(id string) (bool, error) {
+	var newStatus bool
+	err := o.tx.Update(func(cd *ClipboardData) error {
+		if cd.IndexOf(id) < 0 {
+			return fmt.Errorf("%w: %s", ErrNotFound, id)
+		}
+		newStatus = !cd.Pinned[id]
+		if newStatus {
+			cd.Pinned[id] = true
+		} else {
+			delete(cd.Pinned, id)
+		}
+		return nil
+	})
+	return newStatus, err
+}
+
+func (o ops) Query(q Query) ([]Entry, error) {
+	var out []Entry
+	err := o.tx.View(func(cd *ClipboardData) error {
+		for _, e := range cd.History {
+			if q.Limit > 0 && len(out) >= q.Limit {
+				break
+			}
+			if q.Match(e, cd.Pinned[e.ID]) {
+				out = append(out, e)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"slices"
+	"time"
+)
+
+// TrashRetention is how long deleted entries stay restorable
+// (configurable). Zero disables the trash.
+var TrashRetention = 7 * 24 * time.Hour
+
+// TrashedEntry is a deleted history entry kept for restoring.
+type TrashedEntry struct {
+	Entry
+	Pinned    bool      `json:"pinned,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// Discard moves the entry at index i into the trash and returns it.
+func (cd *ClipboardData) Discard(i int, now time.Time) TrashedEntry {
+	t := TrashedEntry{Entry: cd.History[i], Pinned: cd.Pinned[cd.History[i].ID], DeletedAt: now}
+	cd.Remove(i)
+	cd.dropFromTrash(t.ID)
+	cd.Trash = append([]TrashedEntry{t}, cd.Trash...)
+	return t
+}
+
+// DiscardAll moves every entry into the trash, keeping their order, and
+// returns them.
+func (cd *ClipboardData) DiscardAll(now time.Time) []TrashedEntry {
+	discarded := make([]TrashedEntry, 0, len(cd.History))
+	for _, e := range cd.History {
+		cd.dropFromTrash(e.ID)
+		discarded = append(discarded, TrashedEntry{Entry: e, Pinned: cd.Pinned[e.ID], DeletedAt: now})
+	}
+	cd.Clear()
+	cd.Trash = append(discarded, cd.Trash...)
+	return discarded
+}
+
+// TrashIndex returns the index of the trashed entry with the given ID, or -1.
+func (cd *ClipboardData) TrashIndex(id string) int {
+	for i, t := range cd.Trash {
+		if t.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// ResolveTrash finds the trashed entry a reference (index into the trash or
+// ID prefix) points at.
+func (cd *ClipboardData) ResolveTrash(ref string) (int, error) {
+	return resolveRef(ref, len(cd.Trash), func(i int) string { return cd.Trash[i].ID })
+}
+
+// Restore moves the trashed entry at index i back into the history at the
+// position its last use puts it, along with its pin, and returns it. If the
+// same content has been captured again meanwhile only the pin is restored.
+func (cd *ClipboardData) Restore(i int) Entry {
+	t := cd.Trash[i]
+	cd.Trash = append(cd.Trash[:i], cd.Trash[i+1:]...)
+	if cd.IndexOf(t.ID) < 0 {
+		cd.insert(t.Entry)
+	}
+	if t.Pinned {
+		cd.Pinned[t.ID] = true
+	}
+	return t.Entry
+}
+
+// EmptyTrash deletes every trashed entry for good.
+func (cd *ClipboardData) EmptyTrash() {
+	cd.Trash = nil
+}
+
+// dropFromTrash removes a trashed entry with the given ID, if any.
+func (cd *ClipboardData) dropFromTrash(id string) {
+	if i := cd.TrashIndex(id); i >= 0 {
+		cd.Trash = append(cd.Trash[:i], cd.Trash[i+1:]...)
+	}
+}
+
+// expireTrash drops the trashed secrets that have outlived the Sensitive
+// age of p at now, as they would have in the history, and reports whether
+// it dropped any.
+func (cd *ClipboardData) expireTrash(p RetentionPolicy, now time.Time) bool {
+	if p.Sensitive <= 0 {
+		return false
+	}
+	n := len(cd.Trash)
+	cd.Trash = slices.DeleteFunc(cd.Trash, func(t TrashedEntry) bool {
+		return t.Sensitive() && p.Expired(t.Entry, now)
+	})
+	if len(cd.Trash) == 0 {
+		cd.Trash = nil
+	}
+	return len(cd.Trash) < n
+}
+
+// purgeTrash drops trashed entries older than TrashRetention and secrets
+// past the Retention policy, and keeps the trash within MaxHistory entries.
+func purgeTrash(clipData *ClipboardData, now time.Time) {
+	clipData.expireTrash(Retention, now)
+	kept := clipData.Trash[:0]
+	for _, t := range clipData.Trash {
+		if now.Sub(t.DeletedAt) < TrashRetention && len(kept) < MaxHistory {
+			kept = append(kept, t)
+		}
+	}
+	if len(kept) == 0 {
+		kept = nil
+	}
+	clipData.Trash = kept
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github/phaneendra24/goclipboard-manager/config"
+	"github/phaneendra24/goclipboard-manager/storage"
+)
+
+// cmdTrash manages deleted entries: list, restore or empty.
+func cmdTrash(store storage.Store, cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	switch args[0] {
+	case "list":
+		clipData, err := storage.Snapshot(store)
+		if err != nil {
+			return err
+		}
+		if len(clipData.Trash) == 0 {
+			fmt.Println("(trash empty)")
+			return nil
+		}
+		now := time.Now()
+		for i, t := range clipData.Trash {
+			pin := " "
+			if t.Pinned {
+				pin = "*"
+			}
+			fmt.Printf("[%d]%s %s %-4s %6s deleted=%s  %s\n",
+				i, pin, t.ID, t.Type, storage.FormatSize(t.Size),
+				storage.FormatAge(t.DeletedAt, now), storage.Preview(strings.Split(t.DisplayText(), "\n")[0], cfg.Preview.List))
+		}
+		return nil
+
+	case "restore":
+		var restored []storage.Entry
+		err := store.Update(func(clipData *storage.ClipboardData) error {
+			if len(clipData.Trash) == 0 {
+				return errors.New("trash empty")
+			}
+			// Resolve every reference before the trash shifts underneath them
+			var ids []string
+			for _, ref := range args[1:] {
+				i, err := clipData.ResolveTrash(ref)
+				if err != nil {
+					return err
+				}
+				ids = append(ids, clipData.Trash[i].ID)
+			}
+			if len(ids) == 0 {
+				// Default to everything deleted by the most recent delete or clear
+				last := clipData.Trash[0].DeletedAt
+				for _, t := range clipData.Trash {
+					if t.DeletedAt.Equal(last) {
+						ids = append(ids, t.ID)
+					}
+				}
+			}
+			for _, id := range ids {
+				if i := clipData.TrashIndex(id); i >= 0 {
+					restored = append(restored, clipData.Restore(i))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, e := range restored {
+			fmt.Printf("restored %s\n", e.ID)
+		}
+		return nil
+
+	case "empty":
+		err := store.Update(func(clipData *storage.ClipboardData) error {
+			clipData.EmptyTrash()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Println("trash emptied")
+		return nil
+
+	default:
+		return fmt.Errorf("unknown trash command %q (use list, restore or empty)", args[0])
+	}
+}
@@ -15,19 +15,21 @@ import (
 	"fyne.io/fyne/v2/widget"
 
 	clipboardPkg "github/phaneendra24/goclipboard-manager/clipboard"
+	"github/phaneendra24/goclipboard-manager/config"
 	"github/phaneendra24/goclipboard-manager/storage"
 )
 
 // searchEntryWidget extends Entry to forward navigation shortcuts
 type searchEntryWidget struct {
 	widget.Entry
-	onUp       func()
-	onDown     func()
-	onEscape   func()
-	onDelete   func()
-	onPin      func()
-	onPaste    func()
-	onCopy     func()
+	onUp     func()
+	onDown   func()
+	onEscape func()
+	onDelete func()
+	onPin    func()
+	onPaste  func()
+	onCopy   func()
+	onUndo   func()
 }
 
 func (e *searchEntryWidget) TypedKey(key *fyne.KeyEvent) {
@@ -92,32 +94,48 @@ func (e *searchEntryWidget) TypedShortcut(s fyne.Shortcut) {
 					e.onPaste()
 				}
 				return
+			case fyne.KeyZ:
+				if e.onUndo != nil {
+					e.onUndo()
+				}
+				return
 			}
 		}
 	}
 	e.Entry.TypedShortcut(s)
 }
 
-// RunGUI starts the Fyne-based graphical clipboard manager.
-func RunGUI() error {
+// RunGUI starts the Fyne-based graphical clipboard manager on top of store,
+// the history of storage.Profile, with the window, preview and paste
+// settings of cfg. switchProfile opens the configuration and history of
+// another profile when one is picked in the window.
+func RunGUI(store storage.Store, cfg *config.Config, switchProfile func(name string) (*config.Config, storage.Store, error)) error {
 	// Use app ID for better window manager recognition
 	a := app.NewWithID("com.clipcli.manager")
-	
-	// Apply Catppuccin theme
-	a.Settings().SetTheme(&CatppuccinTheme{})
+
+	// Apply the configured theme
+	a.Settings().SetTheme(themeFor(cfg.UI.Theme))
 
 	w := a.NewWindow("📋 Clipboard Manager")
-	w.Resize(fyne.NewSize(700, 500))
+	if storage.Profile != storage.DefaultProfile {
+		w.SetTitle("📋 Clipboard Manager · " + storage.Profile)
+	}
+	w.Resize(fyne.NewSize(float32(cfg.UI.Width), float32(cfg.UI.Height)))
 	w.CenterOnScreen()
 
 	// Load clipboard data (history + pinned)
-	clipData, err := storage.LoadClipboardData()
+	clipData, err := storage.Snapshot(store)
+	if err != nil {
+		return err
+	}
+	// The search index finds queries in the full content of blob entries
+	index, err := storage.OpenIndex(store)
 	if err != nil {
 		return err
 	}
 
 	// Build sorted list: pinned items first, then unpinned
-	buildSortedHistory := func() []string {
+	buildSortedHistory := func() []storage.Entry {
 		pinned := storage.GetPinnedItems(clipData)
 		unpinned := storage.GetUnpinnedItems(clipData)
 		return append(pinned, unpinned...)
@@ -134,6 +152,7 @@ func RunGUI() error {
 	var moveUp, moveDown, deleteSelected func()
 	var closeWindow func()
 	var togglePinSelected, pasteSelected func()
+	var undoLast func()
 
 	searchEntry := &searchEntryWidget{
 		Entry:    widget.Entry{},
@@ -143,12 +162,13 @@ func RunGUI() error {
 		onDelete: func() { deleteSelected() },
 		onPin:    func() { togglePinSelected() },
 		onPaste:  func() { pasteSelected() },
+		onUndo:   func() { undoLast() },
 	}
 	searchEntry.ExtendBaseWidget(searchEntry)
 	searchEntry.SetPlaceHolder("  Search clipboard...")
 
 	// Clean, minimal status bar
-	statusLabel := widget.NewLabel(fmt.Sprintf("⏎ Copy  •  Ctrl+⏎ Paste  •  Ctrl+P Pin  •  Del Remove  │  %d items", len(sortedHist)))
+	statusLabel := widget.NewLabel(fmt.Sprintf("⏎ Copy  •  Ctrl+⏎ Paste  •  Ctrl+P Pin  •  Del Remove  •  Ctrl+Z Undo  │  %d items", len(sortedHist)))
 	statusLabel.Importance = widget.LowImportance
 
 	// List widget
@@ -157,23 +177,28 @@ func RunGUI() error {
 			return len(filtered)
 		},
 		func() fyne.CanvasObject {
-			return widget.NewLabel("template")
+			meta := widget.NewLabel("meta")
+			meta.Importance = widget.LowImportance
+			return container.NewBorder(nil, nil, nil, meta, widget.NewLabel("template"))
 		},
 		func(i widget.ListItemID, o fyne.CanvasObject) {
 			if i < len(filtered) {
 				idx := filtered[i]
 				if idx < len(sortedHist) {
 					item := sortedHist[idx]
-					preview := strings.Split(item, "\n")[0]
-					if len(preview) > 90 {
-						preview = preview[:90] + "…"
-					}
+					preview := storage.Preview(strings.Split(item.DisplayText(), "\n")[0], cfg.Preview.GUI)
 					// Add pin indicator
 					prefix := "  "
-					if clipData.Pinned[item] {
+					if clipData.IsPinned(item.ID) {
 						prefix = "📌"
+					} else if item.Sensitive() {
+						prefix = "🔒"
 					}
-					o.(*widget.Label).SetText(fmt.Sprintf("%s %s", prefix, preview))
+					row := o.(*fyne.Container)
+					row.Objects[0].(*widget.Label).SetText(fmt.Sprintf("%s %s", prefix, preview))
+					row.Objects[1].(*widget.Label).SetText(fmt.Sprintf("[%d] %s · %s · %s · %s · %d× · %s",
+						clipData.IndexOf(item.ID), item.ID[:min(len(item.ID), storage.MinRefPrefix)], item.Type, item.SelectionName(), storage.FormatSize(item.Size), item.UseCount,
+						storage.FormatAge(item.LastSeen, time.Now())))
 				}
 			}
 		},
@@ -191,29 +216,29 @@ func RunGUI() error {
 	refreshAll := func() {
 		sortedHist = buildSortedHistory()
 		list.Refresh()
-		statusLabel.SetText(fmt.Sprintf("⏎ Copy  •  Ctrl+⏎ Paste  •  Ctrl+P Pin  •  Del Remove  │  %d items", len(sortedHist)))
+		statusLabel.SetText(fmt.Sprintf("⏎ Copy  •  Ctrl+⏎ Paste  •  Ctrl+P Pin  •  Del Remove  •  Ctrl+Z Undo  │  %d items", len(sortedHist)))
 	}
 
 	// Fuzzy match function - returns score (higher = better match), -1 = no match
 	fuzzyMatch := func(pattern, text string) int {
 		pattern = strings.ToLower(pattern)
 		text = strings.ToLower(text)
-		
+
 		if pattern == "" {
 			return 0
 		}
-		
+
 		// Check for exact substring match first (highest priority)
 		if strings.Contains(text, pattern) {
 			return 1000 + len(pattern)*10
 		}
-		
+
 		// Fuzzy matching: characters must appear in order
 		pIdx := 0
 		score := 0
 		lastMatchIdx := -1
 		wordStart := true
-		
+
 		for i := 0; i < len(text) && pIdx < len(pattern); i++ {
 			if text[i] == pattern[pIdx] {
 				pIdx++
@@ -232,12 +257,12 @@ func RunGUI() error {
 			// Track word boundaries
 			wordStart = text[i] == ' ' || text[i] == '/' || text[i] == '_' || text[i] == '-'
 		}
-		
+
 		// All pattern characters must be found
 		if pIdx < len(pattern) {
 			return -1
 		}
-		
+
 		return score
 	}
 
@@ -256,19 +281,33 @@ func RunGUI() error {
 				score int
 			}
 			matches := []matchResult{}
-			
+
+			// Entries are matched fuzzily against what is shown of them. The
+			// index finds the blob entries that contain the query's
+			// trigrams beyond their preview; it never rules entries out,
+			// so the results do not depend on what other entries hold
+			candidates, _ := index.Candidates(query)
+			idQuery := strings.ToLower(query)
 			for i, v := range sortedHist {
-				score := fuzzyMatch(query, v)
+				// Entry IDs (or a long enough prefix) jump straight to the top
+				if len(idQuery) >= storage.MinRefPrefix && strings.HasPrefix(v.ID, idQuery) {
+					matches = append(matches, matchResult{index: i, score: 1 << 20})
+					continue
+				}
+				score := fuzzyMatch(query, v.DisplayText())
+				if score < 0 && v.Blob != "" && candidates[v.ID] {
+					score = 1000 // matched beyond the preview
+				}
 				if score >= 0 {
 					matches = append(matches, matchResult{index: i, score: score})
 				}
 			}
-			
+
 			// Sort by score (higher first)
 			sort.Slice(matches, func(a, b int) bool {
 				return matches[a].score > matches[b].score
 			})
-			
+
 			// Extract indices
 			filtered = make([]int, len(matches))
 			for i, m := range matches {
@@ -282,6 +321,56 @@ func RunGUI() error {
 		}
 	}
 
+	// update applies fn to the latest on-disk history in a locked transaction
+	// and adopts the committed result as the displayed data
+	update := func(fn func(*storage.ClipboardData) error) error {
+		var committed *storage.ClipboardData
+		err := store.Update(func(cd *storage.ClipboardData) error {
+			if err := fn(cd); err != nil {
+				return err
+			}
+			committed = cd
+			return nil
+		})
+		if err == nil && committed != nil {
+			clipData = committed
+		}
+		return err
+	}
+
+	// undoable is a reversible change: label describes it, revert undoes it
+	type undoable struct {
+		label  string
+		revert func(*storage.ClipboardData) error
+	}
+	var undoStack []undoable
+
+	// restoreFromTrash returns a revert function that brings back the given
+	// trashed entries
+	restoreFromTrash := func(ids ...string) func(*storage.ClipboardData) error {
+		return func(cd *storage.ClipboardData) error {
+			for _, id := range ids {
+				if i := cd.TrashIndex(id); i >= 0 {
+					cd.Restore(i)
+				}
+			}
+			return nil
+		}
+	}
+
+	// markUsed bumps the use counter of an entry taken from the list
+	markUsed := func(item storage.Entry) {
+		err := update(func(cd *storage.ClipboardData) error {
+			if i := cd.IndexOf(item.ID); i >= 0 {
+				cd.MarkUsed(i)
+			}
+			return nil
+		})
+		if err != nil {
+			dialog.ShowError(err, w)
+		}
+	}
+
 	// Action functions
 	var copySelected func()
 	var copyAndClose func()
@@ -302,9 +391,14 @@ func RunGUI() error {
 		if selectedIndex >= 0 && selectedIndex < len(filtered) {
 			idx := filtered[selectedIndex]
 			if idx < len(sortedHist) {
-				if err := clipboardPkg.CopyToClipboard(sortedHist[idx]); err != nil {
+				text, err := store.Content(sortedHist[idx])
+				if err == nil {
+					err = clipboardPkg.CopyEntry(sortedHist[idx], text)
+				}
+				if err != nil {
 					dialog.ShowError(err, w)
 				} else {
+					markUsed(sortedHist[idx])
 					statusLabel.SetText("✓ Copied to clipboard")
 				}
 			}
@@ -323,18 +417,24 @@ func RunGUI() error {
 		if selectedIndex >= 0 && selectedIndex < len(filtered) {
 			idx := filtered[selectedIndex]
 			if idx < len(sortedHist) {
-				text := sortedHist[idx]
+				text, err := store.Content(sortedHist[idx])
+				if err != nil {
+					dialog.ShowError(err, w)
+					return
+				}
 				// Copy to clipboard first
-				if err := clipboardPkg.CopyToClipboard(text); err != nil {
+				if err := clipboardPkg.CopyEntry(sortedHist[idx], text); err != nil {
 					dialog.ShowError(err, w)
 					return
 				}
+				markUsed(sortedHist[idx])
 				// Close window FIRST so paste goes to the previously focused window
 				w.Close()
 				// Paste in background after window closes
+				paste := cfg.Paste
 				go func() {
-					time.Sleep(100 * time.Millisecond) // Give window time to close
-					clipboardPkg.SimulatePaste()
+					time.Sleep(time.Duration(paste.GUIDelay)) // Give window time to close
+					clipboardPkg.SimulatePaste(paste)
 				}()
 			}
 		}
@@ -346,13 +446,28 @@ func RunGUI() error {
 			if idx < len(sortedHist) {
 				item := sortedHist[idx]
 				savedIndex := selectedIndex // Preserve selection
-				isPinned, err := storage.TogglePin(item)
+				var isPinned bool
+				err := update(func(cd *storage.ClipboardData) error {
+					isPinned = !cd.Pinned[item.ID]
+					if isPinned {
+						cd.Pinned[item.ID] = true
+					} else {
+						delete(cd.Pinned, item.ID)
+					}
+					return nil
+				})
 				if err != nil {
 					dialog.ShowError(err, w)
 					return
 				}
-				// Reload data
-				clipData, _ = storage.LoadClipboardData()
+				undoStack = append(undoStack, undoable{"pin", func(cd *storage.ClipboardData) error {
+					if isPinned {
+						delete(cd.Pinned, item.ID)
+					} else if cd.IndexOf(item.ID) >= 0 {
+						cd.Pinned[item.ID] = true
+					}
+					return nil
+				}})
 				if isPinned {
 					statusLabel.SetText("📌 Pinned")
 				} else {
@@ -378,21 +493,18 @@ func RunGUI() error {
 			if idx < len(sortedHist) {
 				item := sortedHist[idx]
 				savedIndex := selectedIndex // Preserve selection
-				// Remove from history
-				newHist := []string{}
-				for _, h := range clipData.History {
-					if h != item {
-						newHist = append(newHist, h)
+				// Move to the trash (along with its pin) so it can be undone
+				err := update(func(cd *storage.ClipboardData) error {
+					if i := cd.IndexOf(item.ID); i >= 0 {
+						cd.Discard(i, time.Now())
 					}
-				}
-				clipData.History = newHist
-				// Remove from pinned if present
-				delete(clipData.Pinned, item)
-				
-				if err := storage.SaveClipboardData(clipData); err != nil {
+					return nil
+				})
+				if err != nil {
 					dialog.ShowError(err, w)
 					return
 				}
+				undoStack = append(undoStack, undoable{"delete", restoreFromTrash(item.ID)})
 				statusLabel.SetText("✓ Deleted")
 				refreshAll()
 				applyFilter(searchEntry.Text)
@@ -409,12 +521,17 @@ func RunGUI() error {
 	}
 
 	refreshHistory = func() {
-		newData, err := storage.LoadClipboardData()
+		newData, err := storage.Snapshot(store)
+		if err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		newIndex, err := storage.OpenIndex(store)
 		if err != nil {
 			dialog.ShowError(err, w)
 			return
 		}
-		clipData = newData
+		clipData, index = newData, newIndex
 		searchEntry.SetText("")
 		selectedIndex = 0
 		refreshAll()
@@ -425,20 +542,68 @@ func RunGUI() error {
 	}
 
 	clearAll = func() {
-		dialog.ShowConfirm("Clear All", "Delete all clipboard history (including pinned)?", func(confirm bool) {
+		dialog.ShowConfirm("Clear All", "Move all clipboard history (including pinned) to the trash?", func(confirm bool) {
 			if confirm {
-				clipData.History = []string{}
-				clipData.Pinned = make(map[string]bool)
-				if err := storage.SaveClipboardData(clipData); err != nil {
+				var ids []string
+				err := update(func(cd *storage.ClipboardData) error {
+					for _, t := range cd.DiscardAll(time.Now()) {
+						ids = append(ids, t.ID)
+					}
+					return nil
+				})
+				if err != nil {
 					dialog.ShowError(err, w)
 					return
 				}
+				undoStack = append(undoStack, undoable{"clear", restoreFromTrash(ids...)})
 				refreshAll()
 				statusLabel.SetText("✓ Cleared")
 			}
 		}, w)
 	}
 
+	undoLast = func() {
+		if len(undoStack) == 0 {
+			statusLabel.SetText("Nothing to undo")
+			return
+		}
+		last := undoStack[len(undoStack)-1]
+		undoStack = undoStack[:len(undoStack)-1]
+		if err := update(last.revert); err != nil {
+			dialog.ShowError(err, w)
+			return
+		}
+		refreshAll()
+		applyFilter(searchEntry.Text)
+		statusLabel.SetText("↶ Undid " + last.label)
+	}
+
+	// Profile picker: shows the current profile and switches to another one
+	profiles, err := storage.Profiles()
+	if err != nil {
+		return err
+	}
+	profileSelect := widget.NewSelect(profiles, nil)
+	profileSelect.SetSelected(storage.Profile)
+	profileSelect.OnChanged = func(name string) {
+		if name == storage.Profile {
+			return
+		}
+		newCfg, newStore, err := switchProfile(name)
+		if err != nil {
+			dialog.ShowError(err, w)
+			profileSelect.SetSelected(storage.Profile)
+			return
+		}
+		cfg, store = newCfg, newStore
+		a.Settings().SetTheme(themeFor(cfg.UI.Theme))
+		undoStack = nil // undo entries belong to the previous profile's history
+		w.SetTitle("📋 Clipboard Manager · " + name)
+		refreshHistory()
+		statusLabel.SetText(fmt.Sprintf("✓ Profile %s: %d items", name, len(sortedHist)))
+		w.Canvas().Focus(searchEntry)
+	}
+
 	// Navigation helper functions - assign to variables for searchEntry callbacks
 	moveUp = func() {
 		if selectedIndex > 0 {
@@ -475,6 +640,7 @@ func RunGUI() error {
 	shortcutDelete := &desktop.CustomShortcut{KeyName: fyne.KeyD, Modifier: fyne.KeyModifierControl}
 	shortcutBackspace := &desktop.CustomShortcut{KeyName: fyne.KeyBackspace, Modifier: fyne.KeyModifierControl}
 	shortcutClear := &desktop.CustomShortcut{KeyName: fyne.KeyL, Modifier: fyne.KeyModifierControl}
+	shortcutUndo := &desktop.CustomShortcut{KeyName: fyne.KeyZ, Modifier: fyne.KeyModifierControl}
 	shortcutEscape := &desktop.CustomShortcut{KeyName: fyne.KeyEscape, Modifier: fyne.KeyModifierShift} // Shift+Esc always quits
 	// Vim-style navigation: Ctrl+J (down), Ctrl+K (up)
 	shortcutNavDown := &desktop.CustomShortcut{KeyName: fyne.KeyJ, Modifier: fyne.KeyModifierControl}
@@ -486,16 +652,17 @@ func RunGUI() error {
 	w.Canvas().AddShortcut(shortcutDelete, func(s fyne.Shortcut) { deleteSelected() })
 	w.Canvas().AddShortcut(shortcutBackspace, func(s fyne.Shortcut) { deleteSelected() })
 	w.Canvas().AddShortcut(shortcutClear, func(s fyne.Shortcut) { clearAll() })
+	w.Canvas().AddShortcut(shortcutUndo, func(s fyne.Shortcut) { undoLast() })
 	w.Canvas().AddShortcut(shortcutEscape, func(s fyne.Shortcut) { w.Close() })
 	w.Canvas().AddShortcut(shortcutNavDown, func(s fyne.Shortcut) { moveDown() })
 	w.Canvas().AddShortcut(shortcutNavUp, func(s fyne.Shortcut) { moveUp() })
 
 	// Layout
 	content := container.NewBorder(
-		searchEntry,  // top
-		statusLabel,  // bottom
+		container.NewBorder(nil, nil, nil, profileSelect, searchEntry), // top
+		statusLabel, // bottom
 		nil, nil,
-		list,         // center
+		list, // center
 	)
 
 	w.SetContent(content)
@@ -6,16 +6,18 @@ import (
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/theme"
+
+	"github/phaneendra24/goclipboard-manager/config"
 )
 
 // Dracula color palette - https://draculatheme.com/
 // Modified with darker background for user preference
 var (
 	// Base colors - Extra dark
-	draculaBackground = color.NRGBA{R: 18, G: 18, B: 28, A: 255}    // #12121c - very dark
-	draculaCurrent    = color.NRGBA{R: 30, G: 30, B: 46, A: 255}    // #1e1e2e - dark surface
-	draculaSelection  = color.NRGBA{R: 40, G: 40, B: 60, A: 255}    // #28283c - slightly lighter
-	draculaComment    = color.NRGBA{R: 98, G: 114, B: 164, A: 255}  // #6272a4
+	draculaBackground = color.NRGBA{R: 18, G: 18, B: 28, A: 255}   // #12121c - very dark
+	draculaCurrent    = color.NRGBA{R: 30, G: 30, B: 46, A: 255}   // #1e1e2e - dark surface
+	draculaSelection  = color.NRGBA{R: 40, G: 40, B: 60, A: 255}   // #28283c - slightly lighter
+	draculaComment    = color.NRGBA{R: 98, G: 114, B: 164, A: 255} // #6272a4
 
 	// Text colors
 	draculaForeground = color.NRGBA{R: 248, G: 248, B: 242, A: 255} // #f8f8f2
@@ -38,6 +40,14 @@ type CatppuccinTheme = DraculaTheme
 
 var _ fyne.Theme = (*DraculaTheme)(nil)
 
+// themeFor returns the theme named in the config
+func themeFor(name string) fyne.Theme {
+	if name == config.ThemeSystem {
+		return theme.DefaultTheme()
+	}
+	return &DraculaTheme{}
+}
+
 func (d *DraculaTheme) Color(name fyne.ThemeColorName, variant fyne.ThemeVariant) color.Color {
 	switch name {
 	case theme.ColorNameBackground:
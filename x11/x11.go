@@ -0,0 +1,333 @@
+// Package x11 watches X11 selections for changes of owner through the
+// XFixes extension, speaking the X protocol directly over the display
+// socket.
+package x11
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialTimeout bounds connecting to the X server and the setup exchange.
+const dialTimeout = 5 * time.Second
+
+// Core protocol opcodes.
+const (
+	opInternAtom     = 16
+	opQueryExtension = 98
+)
+
+// XFixes minor opcodes and constants.
+const (
+	xfixesQueryVersion          = 0
+	xfixesSelectSelectionInput  = 2
+	xfixesSetSelectionOwnerMask = 1
+	xfixesSetSelectionOwner     = 0 // SelectionNotify subtype
+)
+
+// ErrNoXFixes is returned when the X server lacks the XFixes extension.
+var ErrNoXFixes = errors.New("X server has no XFixes extension")
+
+// order is the byte order the client announces; the server then uses it
+// for everything it sends.
+var order = binary.LittleEndian
+
+// conn is a connection to an X server.
+type conn struct {
+	c    net.Conn
+	r    *bufio.Reader
+	root uint32
+}
+
+// pad returns the padding that brings n up to a multiple of four.
+func pad(n int) int {
+	return (4 - n%4) % 4
+}
+
+// parseDisplay splits a DISPLAY value such as ":0", ":1.0" or
+// "host:10.0" into the address of its server and its display number.
+func parseDisplay(display string) (network, addr, number string, err error) {
+	i := strings.LastIndexByte(display, ':')
+	if i < 0 {
+		return "", "", "", fmt.Errorf("invalid DISPLAY %q", display)
+	}
+	host := display[:i]
+	number, _, _ = strings.Cut(display[i+1:], ".")
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 0 {
+		return "", "", "", fmt.Errorf("invalid DISPLAY %q", display)
+	}
+	if host == "" || host == "unix" {
+		return "unix", "/tmp/.X11-unix/X" + number, number, nil
+	}
+	return "tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)), number, nil
+}
+
+// readAuth returns the MIT-MAGIC-COOKIE-1 for display number on this host
+// from the Xauthority file, or nothing if there is none.
+func readAuth(number string) (name string, data []byte) {
+	path := os.Getenv("XAUTHORITY")
+	if path == "" {
+		path = filepath.Join(os.Getenv("HOME"), ".Xauthority")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", nil
+	}
+	defer f.Close()
+	hostname, _ := os.Hostname()
+	r := bufio.NewReader(f)
+	field := func() ([]byte, error) {
+		var n uint16
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		b := make([]byte, n)
+		_, err := io.ReadFull(r, b)
+		return b, err
+	}
+	for {
+		var family uint16
+		if err := binary.Read(r, binary.BigEndian, &family); err != nil {
+			return "", nil
+		}
+		addr, err1 := field()
+		num, err2 := field()
+		authName, err3 := field()
+		authData, err4 := field()
+		if err := errors.Join(err1, err2, err3, err4); err != nil {
+			return "", nil
+		}
+		const familyLocal, familyWild = 256, 65535
+		local := family == familyWild || family == familyLocal && string(addr) == hostname
+		if local && (len(num) == 0 || string(num) == number) && string(authName) == "MIT-MAGIC-COOKIE-1" {
+			return string(authName), authData
+		}
+	}
+}
+
+// dial connects to the X server named by the DISPLAY environment variable.
+func dial() (*conn, error) {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		return nil, errors.New("DISPLAY is not set")
+	}
+	network, addr, number, err := parseDisplay(display)
+	if err != nil {
+		return nil, err
+	}
+	c, err := net.DialTimeout(network, addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	x := &conn{c: c, r: bufio.NewReader(c)}
+	c.SetDeadline(time.Now().Add(dialTimeout))
+	if err := x.setup(readAuth(number)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("X connection setup: %w", err)
+	}
+	c.SetDeadline(time.Time{})
+	return x, nil
+}
+
+// setup performs the connection handshake and records the root window of
+// the first screen.
+func (x *conn) setup(authName string, authData []byte) error {
+	req := make([]byte, 12, 12+len(authName)+len(authData)+6)
+	req[0] = 'l'
+	order.PutUint16(req[2:], 11) // protocol version 11.0
+	order.PutUint16(req[6:], uint16(len(authName)))
+	order.PutUint16(req[8:], uint16(len(authData)))
+	req = append(req, authName...)
+	req = append(req, make([]byte, pad(len(authName)))...)
+	req = append(req, authData...)
+	req = append(req, make([]byte, pad(len(authData)))...)
+	if _, err := x.c.Write(req); err != nil {
+		return err
+	}
+
+	head := make([]byte, 8)
+	if _, err := io.ReadFull(x.r, head); err != nil {
+		return err
+	}
+	body := make([]byte, int(order.Uint16(head[6:]))*4)
+	if _, err := io.ReadFull(x.r, body); err != nil {
+		return err
+	}
+	switch head[0] {
+	case 0:
+		return fmt.Errorf("refused: %s", strings.TrimSpace(string(body[:min(int(head[1]), len(body))])))
+	case 2:
+		return fmt.Errorf("authentication required: %s", strings.TrimRight(string(body), "\x00 \n"))
+	}
+	if len(body) < 32 {
+		return errors.New("short setup reply")
+	}
+	vendorLen := int(order.Uint16(body[16:]))
+	formats := int(body[21])
+	screen := 32 + vendorLen + pad(vendorLen) + 8*formats
+	if len(body) < screen+4 || body[20] == 0 {
+		return errors.New("no screens")
+	}
+	x.root = order.Uint32(body[screen:])
+	return nil
+}
+
+// request sends a request with the given opcode, data byte and body.
+func (x *conn) request(opcode, data byte, body []byte) error {
+	body = append(body, make([]byte, pad(len(body)))...)
+	req := make([]byte, 4, 4+len(body))
+	req[0], req[1] = opcode, data
+	order.PutUint16(req[2:], uint16(1+len(body)/4))
+	_, err := x.c.Write(append(req, body...))
+	return err
+}
+
+// reply reads the reply to the last request. Setup requests are answered
+// before any event is selected, so nothing else arrives in between.
+func (x *conn) reply() ([]byte, error) {
+	buf := make([]byte, 32)
+	if _, err := io.ReadFull(x.r, buf); err != nil {
+		return nil, err
+	}
+	switch buf[0] {
+	case 0:
+		return nil, fmt.Errorf("X error %d for request %d", buf[1], buf[10])
+	case 1:
+	default:
+		return nil, fmt.Errorf("unexpected event %d", buf[0])
+	}
+	extra := make([]byte, int(order.Uint32(buf[4:]))*4)
+	if _, err := io.ReadFull(x.r, extra); err != nil {
+		return nil, err
+	}
+	return append(buf, extra...), nil
+}
+
+// named builds the body of a request carrying a name.
+func named(name string) []byte {
+	body := make([]byte, 4, 4+len(name))
+	order.PutUint16(body, uint16(len(name)))
+	return append(body, name...)
+}
+
+// internAtom returns the atom for name, creating it if necessary.
+func (x *conn) internAtom(name string) (uint32, error) {
+	if err := x.request(opInternAtom, 0, named(name)); err != nil {
+		return 0, err
+	}
+	rep, err := x.reply()
+	if err != nil {
+		return 0, fmt.Errorf("intern atom %s: %w", name, err)
+	}
+	return order.Uint32(rep[8:]), nil
+}
+
+// initXFixes negotiates XFixes and returns its major opcode and first
+// event code.
+func (x *conn) initXFixes() (opcode, event byte, err error) {
+	if err := x.request(opQueryExtension, 0, named("XFIXES")); err != nil {
+		return 0, 0, err
+	}
+	rep, err := x.reply()
+	if err != nil {
+		return 0, 0, err
+	}
+	if rep[8] == 0 {
+		return 0, 0, ErrNoXFixes
+	}
+	opcode, event = rep[9], rep[10]
+	// Clients must announce the version they speak before using XFixes
+	version := make([]byte, 8)
+	order.PutUint32(version, 5)
+	if err := x.request(opcode, xfixesQueryVersion, version); err != nil {
+		return 0, 0, err
+	}
+	if _, err := x.reply(); err != nil {
+		return 0, 0, fmt.Errorf("XFixes version: %w", err)
+	}
+	return opcode, event, nil
+}
+
+// Watch reports the name of a selection, such as "CLIPBOARD" or "PRIMARY",
+// whenever a client takes ownership of it, that is whenever something new
+// is copied. Changes are sent on the returned channel, which is closed when
+// stop is closed or the connection to the X server is lost. An error is
+// returned right away when the X server cannot be reached or lacks XFixes.
+func Watch(selections []string, stop <-chan struct{}) (<-chan string, error) {
+	x, err := dial()
+	if err != nil {
+		return nil, err
+	}
+	opcode, event, err := x.initXFixes()
+	if err != nil {
+		x.c.Close()
+		return nil, err
+	}
+	names := make(map[uint32]string, len(selections))
+	for _, sel := range selections {
+		atom, err := x.internAtom(sel)
+		if err != nil {
+			x.c.Close()
+			return nil, err
+		}
+		names[atom] = sel
+		body := make([]byte, 12)
+		order.PutUint32(body, x.root)
+		order.PutUint32(body[4:], atom)
+		order.PutUint32(body[8:], xfixesSetSelectionOwnerMask)
+		if err := x.request(opcode, xfixesSelectSelectionInput, body); err != nil {
+			x.c.Close()
+			return nil, err
+		}
+	}
+
+	changes := make(chan string, len(selections))
+	done := make(chan struct{})
+	go func() {
+		// Closing the connection unblocks the reader below
+		select {
+		case <-stop:
+		case <-done:
+		}
+		x.c.Close()
+	}()
+	go func() {
+		defer close(changes)
+		defer close(done)
+		buf := make([]byte, 32)
+		for {
+			if _, err := io.ReadFull(x.r, buf); err != nil {
+				return
+			}
+			if buf[0] == 1 { // stray reply: skip its extra data
+				if _, err := x.r.Discard(int(order.Uint32(buf[4:])) * 4); err != nil {
+					return
+				}
+				continue
+			}
+			if buf[0]&0x7f != event || buf[1] != xfixesSetSelectionOwner {
+				continue
+			}
+			sel, ok := names[order.Uint32(buf[12:])]
+			if !ok {
+				continue
+			}
+			select {
+			case changes <- sel:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	return changes, nil
+}